@@ -3,6 +3,7 @@ module github.com/zchee/zap-encoder
 require (
 	cloud.google.com/go v0.34.0
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/golang/protobuf v1.2.0
 	github.com/google/go-cmp v0.2.1-0.20181115012043-2248b49eaa8e
 	github.com/google/martian v2.1.0+incompatible // indirect
 	github.com/googleapis/gax-go v2.0.2+incompatible // indirect
@@ -10,12 +11,12 @@ require (
 	github.com/stretchr/testify v1.3.0 // indirect
 	go.opencensus.io v0.18.1-0.20181204023538-aab39bd6a98b
 	go.uber.org/atomic v1.3.3-0.20181018215023-8dc6146f7569 // indirect
-	go.uber.org/multierr v1.1.1-0.20180122172545-ddea229ff1df // indirect
+	go.uber.org/multierr v1.1.1-0.20180122172545-ddea229ff1df
 	go.uber.org/zap v1.9.2-0.20180814183419-67bc79d13d15
 	golang.org/x/exp/errors v0.0.0-20190104205336-ae74f88a12a8
 	golang.org/x/net v0.0.0-20190110200230-915654e7eabc // indirect
 	golang.org/x/oauth2 v0.0.0-20190111185915-36a7019397c4
 	golang.org/x/sys v0.0.0-20190114130336-2be517255631 // indirect
 	google.golang.org/api v0.1.0 // indirect
-	google.golang.org/genproto v0.0.0-20190111180523-db91494dd46c // indirect
+	google.golang.org/genproto v0.0.0-20190111180523-db91494dd46c
 )
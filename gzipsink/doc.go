@@ -0,0 +1,7 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gzipsink implements a zapcore.WriteSyncer which posts the gzip compressed
+// batches of the written entries to a generic HTTP log ingest endpoint.
+package gzipsink
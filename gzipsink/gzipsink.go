@@ -0,0 +1,179 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzipsink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultContentType is the Content-Type of the posted batches, which are the
+// newline delimited JSON entries written by the JSON encoders.
+const DefaultContentType = "application/x-ndjson"
+
+// DefaultMaxBufferSize is the default maximum size in bytes of the buffered entries.
+const DefaultMaxBufferSize = 16 << 20
+
+// WriteSyncer buffers the written entries, and posts them as a gzip compressed
+// batch to the endpoint on Sync.
+type WriteSyncer struct {
+	endpoint      string
+	client        *http.Client
+	contentType   string
+	maxBufferSize int
+
+	syncMu sync.Mutex // serializes the Syncs, so the batches are posted in order
+
+	mu  sync.Mutex // guards buf
+	buf []byte
+}
+
+//pragma: compiler time checks whether the WriteSyncer implemented zapcore.WriteSyncer interface.
+var _ zapcore.WriteSyncer = (*WriteSyncer)(nil)
+
+// Option configures the WriteSyncer.
+type Option func(*WriteSyncer)
+
+// WithHTTPClient sets the client used to post the batches. Defaults to http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(ws *WriteSyncer) {
+		ws.client = client
+	}
+}
+
+// WithContentType sets the Content-Type of the posted batches. Defaults to DefaultContentType.
+func WithContentType(contentType string) Option {
+	return func(ws *WriteSyncer) {
+		ws.contentType = contentType
+	}
+}
+
+// WithMaxBufferSize sets the maximum size in bytes of the buffered entries, e.g. while the
+// endpoint is unavailable. Once it's exceeded, the oldest entries are dropped to keep the most
+// recent ones. A zero or negative n buffers without limit. Defaults to DefaultMaxBufferSize.
+func WithMaxBufferSize(n int) Option {
+	return func(ws *WriteSyncer) {
+		ws.maxBufferSize = n
+	}
+}
+
+// New returns a new WriteSyncer which posts the batches to endpoint.
+func New(endpoint string, opts ...Option) *WriteSyncer {
+	ws := &WriteSyncer{
+		endpoint:      endpoint,
+		client:        http.DefaultClient,
+		contentType:   DefaultContentType,
+		maxBufferSize: DefaultMaxBufferSize,
+	}
+	for _, opt := range opts {
+		opt(ws)
+	}
+
+	return ws
+}
+
+// Write implements zapcore.WriteSyncer.
+//
+// Write only buffers p until the next Sync. The oldest entries are dropped once the
+// buffered entries exceed the WithMaxBufferSize size.
+func (ws *WriteSyncer) Write(p []byte) (int, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.buf = ws.limit(append(ws.buf, p...))
+
+	return len(p), nil
+}
+
+// limit drops the oldest entries of buf, which are delimited by the newline, to fit within
+// the maxBufferSize.
+func (ws *WriteSyncer) limit(buf []byte) []byte {
+	if ws.maxBufferSize <= 0 || len(buf) <= ws.maxBufferSize {
+		return buf
+	}
+
+	// drop through the end of the entry the excess ends in.
+	cut := len(buf) - ws.maxBufferSize
+	i := bytes.IndexByte(buf[cut-1:], '\n')
+	if i < 0 {
+		return buf[:0]
+	}
+
+	return buf[cut+i:]
+}
+
+// Sync implements zapcore.WriteSyncer.
+//
+// Sync compresses the buffered entries with a new gzip writer, which is closed per
+// batch, and posts them to the endpoint. The entries are kept for the next Sync
+// if the post fails. The entries written during the post are buffered for the next
+// Sync, without waiting for the post.
+func (ws *WriteSyncer) Sync() error {
+	ws.syncMu.Lock()
+	defer ws.syncMu.Unlock()
+
+	ws.mu.Lock()
+	batch := ws.buf
+	ws.buf = nil
+	ws.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	retry, err := ws.post(batch)
+	if err != nil && retry {
+		// put the batch back before the entries written during the post.
+		ws.mu.Lock()
+		ws.buf = ws.limit(append(batch, ws.buf...))
+		ws.mu.Unlock()
+	}
+
+	return err
+}
+
+// post posts the batch to the endpoint, and reports whether the batch should be posted again
+// if it fails.
+func (ws *WriteSyncer) post(batch []byte) (retry bool, err error) {
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	if _, err := zw.Write(batch); err != nil {
+		return true, err
+	}
+	if err := zw.Close(); err != nil {
+		return true, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ws.endpoint, &body)
+	if err != nil {
+		return true, err
+	}
+	req.Header.Set("Content-Type", ws.contentType)
+	req.Header.Set("Content-Encoding", "gzip")
+
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, copyErr := io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return true, fmt.Errorf("gzipsink: post to %s: %s", ws.endpoint, resp.Status)
+	}
+	// the batch is accepted, so it's not posted again even if the response is broken.
+	if copyErr != nil {
+		return false, fmt.Errorf("gzipsink: read the response of %s: %v", ws.endpoint, copyErr)
+	}
+
+	return false, nil
+}
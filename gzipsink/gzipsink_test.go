@@ -0,0 +1,230 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzipsink_test
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/zchee/zap-encoder/gzipsink"
+)
+
+type post struct {
+	header http.Header
+	body   string
+}
+
+func newTestServer(t *testing.T, status int) (*httptest.Server, func() []post) {
+	t.Helper()
+
+	var (
+		mu    sync.Mutex
+		posts []post
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("gzip.NewReader: %v", err)
+			return
+		}
+		body, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Errorf("read body: %v", err)
+			return
+		}
+		mu.Lock()
+		posts = append(posts, post{header: r.Header, body: string(body)})
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+
+	return srv, func() []post {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]post(nil), posts...)
+	}
+}
+
+func TestWriteSyncer(t *testing.T) {
+	srv, posts := newTestServer(t, http.StatusOK)
+	defer srv.Close()
+
+	ws := gzipsink.New(srv.URL, gzipsink.WithHTTPClient(srv.Client()))
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.TimeKey = ""
+	lg := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(cfg), ws, zapcore.InfoLevel))
+
+	lg.Info("first")
+	lg.Info("second")
+	if got := posts(); len(got) != 0 {
+		t.Fatalf("posted before Sync: %d", len(got))
+	}
+	if err := lg.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	lg.Info("third")
+	if err := lg.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := lg.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`{"level":"info","msg":"first"}` + "\n" + `{"level":"info","msg":"second"}` + "\n",
+		`{"level":"info","msg":"third"}` + "\n",
+	}
+	got := posts()
+	if len(got) != len(want) {
+		t.Fatalf("got %d posts, want %d", len(got), len(want))
+	}
+	for i, p := range got {
+		if p.body != want[i] {
+			t.Errorf("post %d: got body %q, want %q", i, p.body, want[i])
+		}
+		if ce := p.header.Get("Content-Encoding"); ce != "gzip" {
+			t.Errorf("post %d: got Content-Encoding %q, want %q", i, ce, "gzip")
+		}
+		if ct := p.header.Get("Content-Type"); ct != gzipsink.DefaultContentType {
+			t.Errorf("post %d: got Content-Type %q, want %q", i, ct, gzipsink.DefaultContentType)
+		}
+	}
+}
+
+func TestWriteSyncerRetry(t *testing.T) {
+	srv, posts := newTestServer(t, http.StatusServiceUnavailable)
+	defer srv.Close()
+
+	ws := gzipsink.New(srv.URL, gzipsink.WithHTTPClient(srv.Client()))
+	ws.Write([]byte("entry\n"))
+	if err := ws.Sync(); err == nil {
+		t.Fatal("expected the error of the failed post")
+	}
+	if err := ws.Sync(); err == nil {
+		t.Fatal("expected the error of the failed post")
+	}
+
+	got := posts()
+	if len(got) != 2 {
+		t.Fatalf("got %d posts, want 2", len(got))
+	}
+	for i, p := range got {
+		if p.body != "entry\n" {
+			t.Errorf("post %d: got body %q, want %q", i, p.body, "entry\n")
+		}
+	}
+}
+
+func TestWriteSyncerWriteDuringSync(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies []string
+		fail   = true
+	)
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("gzip.NewReader: %v", err)
+			return
+		}
+		body, _ := ioutil.ReadAll(zr)
+
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		failing := fail
+		mu.Unlock()
+		if failing {
+			received <- struct{}{}
+			<-release
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	ws := gzipsink.New(srv.URL, gzipsink.WithHTTPClient(srv.Client()))
+	ws.Write([]byte("first\n"))
+	synced := make(chan error)
+	go func() {
+		synced <- ws.Sync()
+	}()
+	<-received
+
+	written := make(chan struct{})
+	go func() {
+		ws.Write([]byte("second\n"))
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write is blocked by the post in flight")
+	}
+	close(release)
+	if err := <-synced; err == nil {
+		t.Fatal("expected the error of the failed post")
+	}
+
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	if err := ws.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the failed batch is posted again before the entries written during the post.
+	want := []string{"first\n", "first\nsecond\n"}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != len(want) {
+		t.Fatalf("got %d posts, want %d: %q", len(bodies), len(want), bodies)
+	}
+	for i := range want {
+		if bodies[i] != want[i] {
+			t.Errorf("post %d: got body %q, want %q", i, bodies[i], want[i])
+		}
+	}
+}
+
+func TestWriteSyncerWithMaxBufferSize(t *testing.T) {
+	srv, posts := newTestServer(t, http.StatusServiceUnavailable)
+	defer srv.Close()
+
+	ws := gzipsink.New(srv.URL, gzipsink.WithHTTPClient(srv.Client()), gzipsink.WithMaxBufferSize(16))
+	ws.Write([]byte("entry-1\n"))
+	ws.Write([]byte("entry-2\n"))
+	ws.Write([]byte("entry-3\n"))
+	if err := ws.Sync(); err == nil {
+		t.Fatal("expected the error of the failed post")
+	}
+	ws.Write([]byte("entry-4\n"))
+	if err := ws.Sync(); err == nil {
+		t.Fatal("expected the error of the failed post")
+	}
+	ws.Write([]byte("an entry larger than the buffer\n"))
+	if err := ws.Sync(); err != nil {
+		t.Errorf("got error %v, want nothing to post", err)
+	}
+
+	want := []string{"entry-2\nentry-3\n", "entry-3\nentry-4\n"}
+	got := posts()
+	if len(got) != len(want) {
+		t.Fatalf("got %d posts, want %d", len(got), len(want))
+	}
+	for i, p := range got {
+		if p.body != want[i] {
+			t.Errorf("post %d: got body %q, want %q", i, p.body, want[i])
+		}
+	}
+}
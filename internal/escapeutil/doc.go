@@ -0,0 +1,7 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package escapeutil provides the escaping of the special characters for the
+// structured text formats, shared by the encoders.
+package escapeutil
@@ -0,0 +1,107 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package escapeutil
+
+import (
+	"unicode/utf8"
+
+	"go.uber.org/zap/buffer"
+)
+
+const hex = "0123456789abcdef"
+
+// EscapeSDParam appends s to buf as the RFC 5424 SD-PARAM value, without the
+// surrounding quotes. The '"', '\' and ']' characters are escaped with a backslash,
+// and the invalid UTF-8 sequences are replaced with utf8.RuneError.
+func EscapeSDParam(buf *buffer.Buffer, s string) {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '"', r == '\\', r == ']':
+			buf.AppendByte('\\')
+			buf.AppendByte(byte(r))
+		case r == utf8.RuneError && size == 1:
+			buf.AppendString(string(utf8.RuneError))
+		default:
+			buf.AppendString(s[i : i+size])
+		}
+		i += size
+	}
+}
+
+// EscapeCEFExtension appends s to buf as the CEF extension value. The '\' and '='
+// characters are escaped with a backslash, the CR and LF are written as "\r" and "\n",
+// and the invalid UTF-8 sequences are replaced with utf8.RuneError.
+func EscapeCEFExtension(buf *buffer.Buffer, s string) {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '\\', r == '=':
+			buf.AppendByte('\\')
+			buf.AppendByte(byte(r))
+		case r == '\n':
+			buf.AppendString(`\n`)
+		case r == '\r':
+			buf.AppendString(`\r`)
+		case r == utf8.RuneError && size == 1:
+			buf.AppendString(string(utf8.RuneError))
+		default:
+			buf.AppendString(s[i : i+size])
+		}
+		i += size
+	}
+}
+
+// EscapeLogfmtValue appends s to buf as the logfmt value. The value is quoted only
+// if it's empty or contains a space, '=', '"' or a control character, in which case
+// the '"' and '\' characters and the control characters are escaped as in Go string
+// literals. The invalid UTF-8 sequences are replaced with utf8.RuneError.
+func EscapeLogfmtValue(buf *buffer.Buffer, s string) {
+	if !needsLogfmtQuote(s) {
+		buf.AppendString(s)
+		return
+	}
+
+	buf.AppendByte('"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '"', r == '\\':
+			buf.AppendByte('\\')
+			buf.AppendByte(byte(r))
+		case r == '\n':
+			buf.AppendString(`\n`)
+		case r == '\r':
+			buf.AppendString(`\r`)
+		case r == '\t':
+			buf.AppendString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			buf.AppendString(`\u00`)
+			buf.AppendByte(hex[r>>4])
+			buf.AppendByte(hex[r&0xf])
+		case r == utf8.RuneError && size == 1:
+			buf.AppendString(string(utf8.RuneError))
+		default:
+			buf.AppendString(s[i : i+size])
+		}
+		i += size
+	}
+	buf.AppendByte('"')
+}
+
+func needsLogfmtQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r <= ' ' || r == '=' || r == '"' || r == 0x7f || (r == utf8.RuneError && size == 1) {
+			return true
+		}
+		i += size
+	}
+
+	return false
+}
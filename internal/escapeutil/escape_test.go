@@ -0,0 +1,76 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package escapeutil
+
+import (
+	"testing"
+
+	"go.uber.org/zap/buffer"
+)
+
+type escapeTest struct {
+	name string
+	in   string
+	want string
+}
+
+func testEscape(t *testing.T, escape func(*buffer.Buffer, string), tests []escapeTest) {
+	t.Helper()
+
+	pool := buffer.NewPool()
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			buf := pool.Get()
+			defer buf.Free()
+
+			buf.AppendString("prefix:")
+			escape(buf, tt.in)
+			if got, want := buf.String(), "prefix:"+tt.want; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestEscapeSDParam(t *testing.T) {
+	testEscape(t, EscapeSDParam, []escapeTest{
+		{name: "empty", in: "", want: ""},
+		{name: "plain", in: "value 1=2", want: "value 1=2"},
+		{name: "quote", in: `say "hi"`, want: `say \"hi\"`},
+		{name: "backslash", in: `C:\tmp`, want: `C:\\tmp`},
+		{name: "bracket", in: "[a]", want: `[a\]`},
+		{name: "utf8", in: "ログ ✓", want: "ログ ✓"},
+		{name: "invalid utf8", in: "a\xffb", want: "a\ufffdb"},
+	})
+}
+
+func TestEscapeCEFExtension(t *testing.T) {
+	testEscape(t, EscapeCEFExtension, []escapeTest{
+		{name: "empty", in: "", want: ""},
+		{name: "plain", in: "value | 1", want: "value | 1"},
+		{name: "equals", in: "a=b", want: `a\=b`},
+		{name: "backslash", in: `C:\tmp`, want: `C:\\tmp`},
+		{name: "newlines", in: "a\r\nb", want: `a\r\nb`},
+		{name: "utf8", in: "ログ ✓", want: "ログ ✓"},
+		{name: "invalid utf8", in: "a\xffb", want: "a\ufffdb"},
+	})
+}
+
+func TestEscapeLogfmtValue(t *testing.T) {
+	testEscape(t, EscapeLogfmtValue, []escapeTest{
+		{name: "empty", in: "", want: `""`},
+		{name: "plain", in: "value", want: "value"},
+		{name: "space", in: "a b", want: `"a b"`},
+		{name: "equals", in: "a=b", want: `"a=b"`},
+		{name: "quote", in: `say "hi"`, want: `"say \"hi\""`},
+		{name: "backslash only", in: `C:\tmp`, want: `C:\tmp`},
+		{name: "backslash quoted", in: `C:\my tmp`, want: `"C:\\my tmp"`},
+		{name: "control", in: "a\tb\nc\x01", want: `"a\tb\nc\u0001"`},
+		{name: "utf8", in: "ログ✓", want: "ログ✓"},
+		{name: "utf8 quoted", in: "ログ ✓", want: `"ログ ✓"`},
+		{name: "invalid utf8", in: "a\xffb", want: "\"a\ufffdb\""},
+	})
+}
@@ -0,0 +1,20 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testutil
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// FieldsToMap encodes fields with a zapcore.MapObjectEncoder and returns the result,
+// for the assertions on the field helpers without depending on the zapcore.Field
+// internals. The objects and arrays are encoded into nested maps and slices.
+func FieldsToMap(fields []zapcore.Field) map[string]interface{} {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	return enc.Fields
+}
@@ -0,0 +1,47 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testutil
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestFieldsToMap(t *testing.T) {
+	fields := []zapcore.Field{
+		zap.String("string", "value"),
+		zap.Int("int", 42),
+		zap.Bool("bool", true),
+		zap.Float64("float", 1.5),
+		zap.Duration("duration", time.Second),
+		zap.Strings("strings", []string{"a", "b"}),
+		zap.Error(errors.New("failed")),
+		zap.Object("object", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddString("key", "nested")
+			return nil
+		})),
+		zap.Namespace("ns"),
+		zap.String("inner", "value"),
+	}
+	want := map[string]interface{}{
+		"string":   "value",
+		"int":      int64(42),
+		"bool":     true,
+		"float":    1.5,
+		"duration": time.Second,
+		"strings":  []interface{}{"a", "b"},
+		"error":    "failed",
+		"object":   map[string]interface{}{"key": "nested"},
+		"ns":       map[string]interface{}{"inner": "value"},
+	}
+	if diff := cmp.Diff(FieldsToMap(fields), want); diff != "" {
+		t.Errorf("(-got, +want)\n%s", diff)
+	}
+}
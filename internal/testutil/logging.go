@@ -3,3 +3,57 @@
 // license that can be found in the LICENSE file.
 
 package testutil
+
+import (
+	"sync"
+
+	sdlogging "cloud.google.com/go/logging"
+)
+
+// FakeLogger is an in-memory Stackdriver logger which records the delivered
+// entries instead of sending them to the logging service. It is safe for use
+// with multiple goroutines.
+type FakeLogger struct {
+	mu      sync.Mutex
+	entries []sdlogging.Entry
+	flushes int
+
+	// FlushErr is returned from every Flush call.
+	FlushErr error
+}
+
+// NewFakeLogger returns a new FakeLogger.
+func NewFakeLogger() *FakeLogger {
+	return &FakeLogger{}
+}
+
+// Log records e.
+func (l *FakeLogger) Log(e sdlogging.Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, e)
+}
+
+// Flush counts the call and returns FlushErr.
+func (l *FakeLogger) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.flushes++
+	return l.FlushErr
+}
+
+// Entries returns a copy of the recorded entries.
+func (l *FakeLogger) Entries() []sdlogging.Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := make([]sdlogging.Entry, len(l.entries))
+	copy(entries, l.entries)
+	return entries
+}
+
+// Flushes returns the number of Flush calls.
+func (l *FakeLogger) Flushes() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.flushes
+}
@@ -7,9 +7,12 @@ package uid
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
+	"unicode"
 )
 
 // A Space manages a set of unique IDs distinguished by a prefix.
@@ -20,6 +23,15 @@ type Space struct {
 	re     *regexp.Regexp
 	count  int32 // atomic
 	short  bool
+	parts  []string // namespace segments of Prefix
+
+	pattern *regexp.Regexp // see Options.NamePattern
+
+	// stamp is the prefix, timestamp and separators shared by all the IDs, and
+	// stampTime the timestamp it embeds. They are formatted once by NewSpace so
+	// New only has to append the counter.
+	stamp     string
+	stampTime time.Time
 }
 
 // Options are optional values for a Space.
@@ -35,15 +47,23 @@ type Options struct {
 	// e.x. normal: gotest-20181030-59751273685000-0001
 	// e.x. short:  gotest-1540917351273685000-01
 	Short bool
+
+	// NamePattern, if set, is the naming rule of the resources the IDs are used
+	// for. NewValid reports an error for the IDs which don't match it.
+	NamePattern *regexp.Regexp
 }
 
 // NewSpace creates a new UID space. A UID Space is used to generate unique IDs.
 func NewSpace(prefix string, opts *Options) *Space {
-	var short bool
+	var (
+		short   bool
+		pattern *regexp.Regexp
+	)
 	sep := '-'
 	tm := time.Now().UTC()
 	if opts != nil {
 		short = opts.Short
+		pattern = opts.NamePattern
 		if opts.Sep != 0 {
 			sep = opts.Sep
 		}
@@ -54,19 +74,112 @@ func NewSpace(prefix string, opts *Options) *Space {
 	var re string
 
 	if short {
-		re = fmt.Sprintf(`^%s%[2]c(\d+)%[2]c\d+$`, regexp.QuoteMeta(prefix), sep)
+		re = fmt.Sprintf(`^%s%[2]c(\d+)%[2]c(\d+)$`, regexp.QuoteMeta(prefix), sep)
 	} else {
-		re = fmt.Sprintf(`^%s%[2]c(\d{4})(\d{2})(\d{2})%[2]c(\d+)%[2]c\d+$`,
+		re = fmt.Sprintf(`^%s%[2]c(\d{4})(\d{2})(\d{2})%[2]c(\d+)%[2]c(\d+)$`,
 			regexp.QuoteMeta(prefix), sep)
 	}
 
-	return &Space{
+	s := &Space{
 		Prefix: prefix,
 		Sep:    sep,
 		Time:   tm,
 		re:     regexp.MustCompile(re),
 		short:  short,
+		parts:  []string{prefix},
+	}
+	s.pattern = pattern
+	s.stamp, s.stampTime = s.formatStamp(s.Time)
+	return s
+}
+
+// formatStamp formats the part of the IDs preceding the counter with the
+// timestamp tm, and returns it with the timestamp it embeds.
+func (s *Space) formatStamp(tm time.Time) (string, time.Time) {
+	if s.short {
+		ns := tm.UnixNano()
+		return fmt.Sprintf("%s%c%d%c", s.Prefix, s.Sep, ns, s.Sep), time.Unix(ns/1e9, ns%1e9)
+	}
+
+	// Write the time as a date followed by nanoseconds from midnight of that date.
+	// That makes it easier to see the approximate time of the ID when it is displayed.
+	y, m, d := tm.Date()
+	ns := tm.Sub(time.Date(y, m, d, 0, 0, 0, 0, time.UTC))
+	stamp := fmt.Sprintf("%s%c%04d%02d%02d%c%d%c", s.Prefix, s.Sep, y, m, d, s.Sep, ns, s.Sep)
+	return stamp, time.Date(y, m, d, 0, 0, 0, int(ns), time.UTC)
+}
+
+// counterWidth returns the number of the zero-padded counter digits of the IDs.
+func (s *Space) counterWidth() int {
+	if s.short {
+		return 2
+	}
+	return 4
+}
+
+// Template returns a sample of the shape of the IDs of s, e.g. "prefix-YYYYMMDD-NNN-0000",
+// where NNN is the variable-width nanoseconds since midnight, or since the Unix epoch for
+// the short spaces, and 0000 the zero-padded counter.
+func (s *Space) Template() string {
+	if s.short {
+		return fmt.Sprintf("%s%[2]cNNN%[2]c%s", s.Prefix, s.Sep, strings.Repeat("0", s.counterWidth()))
 	}
+	return fmt.Sprintf("%s%[2]cYYYYMMDD%[2]cNNN%[2]c%s", s.Prefix, s.Sep, strings.Repeat("0", s.counterWidth()))
+}
+
+// String implements fmt.Stringer. It describes the format of the IDs of s, to see why
+// the IDs of two spaces collide.
+func (s *Space) String() string {
+	layout := "date and nanoseconds since midnight"
+	if s.short {
+		layout = "nanoseconds since the Unix epoch"
+	}
+	return fmt.Sprintf("uid.Space{prefix: %q, separator: %q, time: %s (%s), counter: %d digits, template: %q}",
+		s.Prefix, s.Sep, layout, s.Time.Format(time.RFC3339Nano), s.counterWidth(), s.Template())
+}
+
+// NewSpaceE is like NewSpace, but returns an error if the space is misconfigured.
+// See Validate.
+func NewSpaceE(prefix string, opts *Options) (*Space, error) {
+	s := NewSpace(prefix, opts)
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Validate reports an error if the UIDs of s can't be split back into their parts
+// unambiguously: the separator is a digit, or the prefix contains the separator
+// (other than between Namespace segments) or whitespace or control characters.
+func (s *Space) Validate() error {
+	if unicode.IsDigit(s.Sep) {
+		return fmt.Errorf("uid: separator %q must not be a digit", s.Sep)
+	}
+	for _, part := range s.parts {
+		if strings.ContainsRune(part, s.Sep) {
+			return fmt.Errorf("uid: prefix %q must not contain the separator %q", part, s.Sep)
+		}
+		for _, r := range part {
+			if unicode.IsSpace(r) || unicode.IsControl(r) {
+				return fmt.Errorf("uid: prefix %q contains illegal character %q", part, r)
+			}
+		}
+	}
+	return nil
+}
+
+// Namespace returns a child space whose prefix is the prefix of s and sub joined
+// by the separator. The child space inherits the separator, timestamp and
+// shortness of s, but has its own counter.
+func (s *Space) Namespace(sub string) *Space {
+	ns := NewSpace(s.Prefix+string(s.Sep)+sub, &Options{
+		Sep:         s.Sep,
+		Time:        s.Time,
+		Short:       s.short,
+		NamePattern: s.pattern,
+	})
+	ns.parts = append(append([]string(nil), s.parts...), sub)
+	return ns
 }
 
 // New generates a new unique ID. The ID consists of the Space's prefix, a
@@ -76,6 +189,60 @@ func NewSpace(prefix string, opts *Options) *Space {
 // Aside from the characters in the prefix, IDs contain only letters, numbers
 // and sep.
 func (s *Space) New() string {
+	id, _, _ := s.NewMeta()
+	return id
+}
+
+// NewValid is like New, but returns an error if the ID doesn't match the
+// Options.NamePattern of s. Since all the IDs of s share the same shape,
+// generating another ID wouldn't help, and the space should be fixed instead.
+func (s *Space) NewValid() (string, error) {
+	id := s.New()
+	if s.pattern != nil && !s.pattern.MatchString(id) {
+		return "", fmt.Errorf("uid: ID %q doesn't match the name pattern %q", id, s.pattern)
+	}
+	return id, nil
+}
+
+// MustNewValid is like NewValid, but panics if the ID is invalid.
+func (s *Space) MustNewValid() string {
+	id, err := s.NewValid()
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// NewMeta is like New, but also returns the timestamp and the counter value
+// embedded in the ID, as returned by Parse.
+func (s *Space) NewMeta() (id string, t time.Time, seq int) {
+	c := s.next()
+	return s.format(s.stamp, c), s.stampTime, c
+}
+
+// Iterator returns a function which yields the next ID of s on each call
+// until n IDs are produced, and then returns false. The IDs are made lazily.
+func (s *Space) Iterator(n int) func() (string, bool) {
+	var made int32
+	return func() (string, bool) {
+		if atomic.AddInt32(&made, 1) > int32(n) {
+			return "", false
+		}
+		return s.New(), true
+	}
+}
+
+// NewWithTime is like New, but embeds tm rather than the Time of s, e.g. to
+// back-date the fixtures. It shares the counter of s. Timestamp and Parse
+// recover tm, with the precision of the nanoseconds.
+func (s *Space) NewWithTime(tm time.Time) string {
+	c := s.next()
+	stamp, _ := s.formatStamp(tm.UTC())
+	return s.format(stamp, c)
+}
+
+// next advances the counter, and returns it.
+func (s *Space) next() int {
 	c := atomic.AddInt32(&s.count, 1)
 
 	if s.short && c > 99 {
@@ -86,17 +253,24 @@ func (s *Space) New() string {
 		panic("New called more than 9999 times. Ran out of IDs.")
 	}
 
-	if s.short {
-		return fmt.Sprintf("%s%c%d%c%02d", s.Prefix, s.Sep, s.Time.UnixNano(), s.Sep, c)
+	return int(c)
+}
+
+// format returns the ID of the counter c with the stamp.
+func (s *Space) format(stamp string, c int) string {
+	digits := 1
+	for n := c; n >= 10; n /= 10 {
+		digits++
 	}
 
-	// Write the time as a date followed by nanoseconds from midnight of that date.
-	// That makes it easier to see the approximate time of the ID when it is displayed.
-	y, m, d := s.Time.Date()
-	ns := s.Time.Sub(time.Date(y, m, d, 0, 0, 0, 0, time.UTC))
+	// Build the ID on the stack so the returned string is the only allocation, unless the
+	// prefix is unusually long.
+	var buf [64]byte
+	b := append(buf[:0], stamp...)
 	// Zero-pad the counter for lexical sort order for IDs with the same timestamp.
-	return fmt.Sprintf("%s%c%04d%02d%02d%c%d%c%04d",
-		s.Prefix, s.Sep, y, m, d, s.Sep, ns, s.Sep, c)
+	b = append(b, "0000"[:s.counterWidth()-digits]...)
+	b = strconv.AppendInt(b, int64(c), 10)
+	return string(b)
 }
 
 // Timestamp extracts the timestamp of uid, which must have been generated by
@@ -125,6 +299,76 @@ func (s *Space) Timestamp(uid string) (time.Time, bool) {
 	return time.Date(y, time.Month(m), d, 0, 0, 0, ns, time.UTC), true
 }
 
+// Parse extracts the timestamp and the counter value of uid, which must have
+// been generated by s. The last return value is true on success, false if there
+// was a problem.
+func (s *Space) Parse(uid string) (t time.Time, seq int, ok bool) {
+	t, ok = s.Timestamp(uid)
+	if !ok {
+		return time.Time{}, 0, false
+	}
+	subs := s.re.FindStringSubmatch(uid)
+	seq, err := strconv.Atoi(subs[len(subs)-1])
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	return t, seq, true
+}
+
+// SortIDs sorts uids in place in chronological order, oldest first, by their
+// timestamp and then by their counter value. IDs which weren't generated by s
+// are placed last, in their original order.
+func (s *Space) SortIDs(uids []string) {
+	type key struct {
+		ts  time.Time
+		seq int
+		ok  bool
+	}
+	keys := make(map[string]key, len(uids))
+	for _, uid := range uids {
+		ts, seq, ok := s.Parse(uid)
+		keys[uid] = key{ts: ts, seq: seq, ok: ok}
+	}
+
+	sort.SliceStable(uids, func(i, j int) bool {
+		ki, kj := keys[uids[i]], keys[uids[j]]
+		switch {
+		case !ki.ok || !kj.ok:
+			return ki.ok && !kj.ok
+		case !ki.ts.Equal(kj.ts):
+			return ki.ts.Before(kj.ts)
+		default:
+			return ki.seq < kj.seq
+		}
+	})
+}
+
+// Bucketize groups uids by the age thresholds they exceed, e.g. to warn about
+// the IDs older than an hour and delete those older than a day. The i-th bucket
+// holds the IDs whose age exceeds thresholds[i] but no larger threshold. The IDs
+// which exceed no threshold, or weren't generated by s, are excluded.
+func (s *Space) Bucketize(uids []string, thresholds ...time.Duration) [][]string {
+	buckets := make([][]string, len(thresholds))
+	now := time.Now()
+	for _, uid := range uids {
+		ts, ok := s.Timestamp(uid)
+		if !ok {
+			continue
+		}
+		age := now.Sub(ts)
+		bucket := -1
+		for i, d := range thresholds {
+			if age > d && (bucket < 0 || d > thresholds[bucket]) {
+				bucket = i
+			}
+		}
+		if bucket >= 0 {
+			buckets[bucket] = append(buckets[bucket], uid)
+		}
+	}
+	return buckets
+}
+
 // Older reports whether uid was created by m and has a timestamp older than
 // the current time by at least d.
 func (s *Space) Older(uid string, d time.Duration) bool {
@@ -0,0 +1,76 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uid
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// naiveSpace generates the IDs by reading the wall clock under a lock on every call,
+// which is the approach Space avoids by fixing the timestamp at creation.
+type naiveSpace struct {
+	mu     sync.Mutex
+	prefix string
+	count  int
+}
+
+func (s *naiveSpace) New() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.count++
+	return fmt.Sprintf("%s-%d-%04d", s.prefix, time.Now().UnixNano(), s.count%10000)
+}
+
+func BenchmarkNewParallel(b *testing.B) {
+	b.Run("Space", func(b *testing.B) {
+		s := NewSpace("bench", nil)
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				// a Space only has room for 9999 IDs; rewind the counter to keep the
+				// benchmark running, uniqueness is not the concern here.
+				if atomic.LoadInt32(&s.count) > 9000 {
+					atomic.StoreInt32(&s.count, 0)
+				}
+				_ = s.New()
+			}
+		})
+	})
+
+	b.Run("Naive", func(b *testing.B) {
+		s := &naiveSpace{prefix: "bench"}
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_ = s.New()
+			}
+		})
+	})
+}
+
+func BenchmarkSpaceNew(b *testing.B) {
+	for _, short := range []bool{false, true} {
+		b.Run(fmt.Sprintf("short=%t", short), func(b *testing.B) {
+			s := NewSpace("bench", &Options{Short: short})
+			max := int32(9000)
+			if short {
+				max = 90
+			}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				// rewind the counter as in BenchmarkNewParallel.
+				if s.count > max {
+					s.count = 0
+				}
+				_ = s.New()
+			}
+		})
+	}
+}
@@ -6,6 +6,8 @@ package uid
 
 import (
 	"fmt"
+	"regexp"
+	"sync"
 	"testing"
 	"time"
 )
@@ -76,3 +78,320 @@ func TestShorter(t *testing.T) {
 		t.Fatalf("expected to get %v, got %v", now, got)
 	}
 }
+
+func TestNamespace(t *testing.T) {
+	tm := time.Date(2017, 1, 6, 0, 0, 0, 21, time.UTC)
+	suite := NewSpace("suite", &Options{Sep: '_', Time: tm})
+	if got, want := suite.New(), "suite_20170106_21_0001"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	tc := suite.Namespace("subsuite").Namespace("case")
+	if got, want := tc.Prefix, "suite_subsuite_case"; got != want {
+		t.Errorf("got prefix %q, want %q", got, want)
+	}
+	uid := tc.New()
+	if want := "suite_subsuite_case_20170106_21_0001"; uid != want {
+		t.Errorf("got %q, want %q", uid, want)
+	}
+	if got, ok := tc.Timestamp(uid); !ok || !got.Equal(tm) {
+		t.Errorf("got (%s, %t), want (%s, true)", got, ok, tm)
+	}
+
+	if got, want := suite.New(), "suite_20170106_21_0002"; got != want {
+		t.Errorf("parent counter: got %q, want %q", got, want)
+	}
+
+	short := NewSpace("uid", &Options{Short: true, Time: tm}).Namespace("sub")
+	if got, want := short.New(), fmt.Sprintf("uid-sub-%d-01", tm.UnixNano()); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNewSpaceE(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		opts    *Options
+		wantErr bool
+	}{
+		{prefix: "gotest", opts: nil, wantErr: false},
+		{prefix: "go_test", opts: nil, wantErr: false},
+		{prefix: "go-test", opts: nil, wantErr: true},
+		{prefix: "go_test", opts: &Options{Sep: '_'}, wantErr: true},
+		{prefix: "gotest", opts: &Options{Sep: '7'}, wantErr: true},
+		{prefix: "go test", opts: nil, wantErr: true},
+		{prefix: "go\ttest", opts: &Options{Short: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		s, err := NewSpaceE(tt.prefix, tt.opts)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewSpaceE(%q, %+v): got error %v, want error %t", tt.prefix, tt.opts, err, tt.wantErr)
+		}
+		if (s == nil) != tt.wantErr {
+			t.Errorf("NewSpaceE(%q, %+v): got space %v", tt.prefix, tt.opts, s)
+		}
+	}
+
+	s, err := NewSpaceE("suite", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Namespace("subsuite").Namespace("case").Validate(); err != nil {
+		t.Errorf("namespaced space: got error %v", err)
+	}
+	if err := s.Namespace("sub-suite").Validate(); err == nil {
+		t.Error("namespace containing the separator: got no error")
+	}
+}
+
+func TestSortIDs(t *testing.T) {
+	tests := []struct {
+		name string
+		s    *Space
+		ids  []string
+		want []string
+	}{
+		{
+			name: "normal",
+			s:    NewSpace("uid", nil),
+			ids: []string{
+				"other-20170106-1-0001",
+				"uid-20170107-5-0001",
+				"uid-20170106-900-0001",
+				"uid-invalid",
+				"uid-20170106-1000-0002",
+				"uid-20170106-1000-0010",
+				"uid-20170106-1000-0001",
+			},
+			want: []string{
+				"uid-20170106-900-0001",
+				"uid-20170106-1000-0001",
+				"uid-20170106-1000-0002",
+				"uid-20170106-1000-0010",
+				"uid-20170107-5-0001",
+				"other-20170106-1-0001",
+				"uid-invalid",
+			},
+		},
+		{
+			name: "short",
+			s:    NewSpace("uid", &Options{Short: true}),
+			ids: []string{
+				"uid-1000-02",
+				"uid-999-01",
+				"uid-x-01",
+				"uid-1000-01",
+			},
+			want: []string{
+				"uid-999-01",
+				"uid-1000-01",
+				"uid-1000-02",
+				"uid-x-01",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got := append([]string(nil), tt.ids...)
+			tt.s.SortIDs(got)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewMeta(t *testing.T) {
+	tm := time.Date(2017, 1, 6, 0, 0, 0, 21, time.UTC)
+	for _, s := range []*Space{
+		NewSpace("prefix", &Options{Time: tm}),
+		NewSpace("prefix", &Options{Time: tm, Short: true}),
+	} {
+		for i := 1; i <= 2; i++ {
+			id, gotTime, gotSeq := s.NewMeta()
+			wantTime, wantSeq, ok := s.Parse(id)
+			if !ok {
+				t.Fatalf("%s: Parse failed", id)
+			}
+			if !gotTime.Equal(wantTime) || !gotTime.Equal(tm) {
+				t.Errorf("%s: got time %s, want %s", id, gotTime, wantTime)
+			}
+			if gotSeq != wantSeq || gotSeq != i {
+				t.Errorf("%s: got seq %d, want %d", id, gotSeq, wantSeq)
+			}
+		}
+	}
+}
+
+func TestNewConcurrent(t *testing.T) {
+	const (
+		goroutines = 8
+		perG       = 1000
+	)
+
+	s := NewSpace("uid", nil)
+	ids := make(chan string, goroutines*perG)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perG; j++ {
+				ids <- s.New()
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[string]bool, goroutines*perG)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("duplicated ID %q", id)
+		}
+		seen[id] = true
+		if _, seq, ok := s.Parse(id); !ok || seq < 1 || seq > goroutines*perG {
+			t.Fatalf("got invalid ID %q", id)
+		}
+	}
+	if len(seen) != goroutines*perG {
+		t.Errorf("got %d IDs, want %d", len(seen), goroutines*perG)
+	}
+}
+
+func TestBucketize(t *testing.T) {
+	now := time.Now()
+	newID := func(age time.Duration) string {
+		return NewSpace("uid", &Options{Time: now.Add(-age)}).New()
+	}
+	fresh := newID(time.Minute)
+	stale := newID(2 * time.Hour)
+	staler := newID(12 * time.Hour)
+	expired := newID(48 * time.Hour)
+	other := NewSpace("other", &Options{Time: now.Add(-48 * time.Hour)}).New()
+
+	s := NewSpace("uid", nil)
+	ids := []string{fresh, expired, stale, other, staler, "uid-invalid"}
+	for _, thresholds := range [][]time.Duration{
+		{time.Hour, 24 * time.Hour},
+		{24 * time.Hour, time.Hour},
+	} {
+		got := s.Bucketize(ids, thresholds...)
+		want := map[time.Duration][]string{
+			time.Hour:      {stale, staler},
+			24 * time.Hour: {expired},
+		}
+		if len(got) != len(thresholds) {
+			t.Fatalf("%v: got %d buckets, want %d", thresholds, len(got), len(thresholds))
+		}
+		for i, d := range thresholds {
+			if fmt.Sprint(got[i]) != fmt.Sprint(want[d]) {
+				t.Errorf("%v: bucket %s: got %q, want %q", thresholds, d, got[i], want[d])
+			}
+		}
+	}
+}
+
+func TestNewValid(t *testing.T) {
+	// e.g. the GCS bucket names: lowercase letters, digits, dashes and underscores.
+	pattern := regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{1,61}[a-z0-9]$`)
+
+	s := NewSpace("bucket", &Options{NamePattern: pattern})
+	id, err := s.NewValid()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pattern.MatchString(id) {
+		t.Errorf("got %q, which doesn't match %s", id, pattern)
+	}
+	if id := s.Namespace("child").MustNewValid(); !pattern.MatchString(id) {
+		t.Errorf("got %q, which doesn't match %s", id, pattern)
+	}
+
+	invalid := NewSpace("Bucket.Name", &Options{NamePattern: pattern})
+	if id, err := invalid.NewValid(); err == nil {
+		t.Errorf("got %q, want an error", id)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("MustNewValid didn't panic")
+			}
+		}()
+		invalid.MustNewValid()
+	}()
+
+	if _, err := NewSpace("Bucket.Name", nil).NewValid(); err != nil {
+		t.Errorf("got error %v without the pattern", err)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	tm := time.Date(2017, 1, 6, 0, 0, 0, 21, time.UTC)
+	for _, test := range []struct {
+		opts *Options
+		want string
+	}{
+		{&Options{Time: tm}, "prefix-YYYYMMDD-NNN-0000"},
+		{&Options{Sep: '_', Time: tm}, "prefix_YYYYMMDD_NNN_0000"},
+		{&Options{Short: true, Time: tm}, "prefix-NNN-00"},
+	} {
+		s := NewSpace("prefix", test.opts)
+		if got := s.Template(); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+
+	s := NewSpace("prefix", &Options{Time: tm})
+	want := `uid.Space{prefix: "prefix", separator: '-', time: date and nanoseconds since midnight (2017-01-06T00:00:00.000000021Z), counter: 4 digits, template: "prefix-YYYYMMDD-NNN-0000"}`
+	if got := fmt.Sprint(s); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestNewWithTime(t *testing.T) {
+	back := time.Date(2016, 2, 29, 23, 59, 59, 123456789, time.FixedZone("JST", 9*60*60))
+	for _, short := range []bool{false, true} {
+		s := NewSpace("prefix", &Options{Short: short})
+		s.New()
+
+		uid := s.NewWithTime(back)
+		got, seq, ok := s.Parse(uid)
+		if !ok {
+			t.Fatalf("short=%t: can't parse %q", short, uid)
+		}
+		if !got.Equal(back) {
+			t.Errorf("short=%t: got %s, want %s", short, got, back)
+		}
+		if seq != 2 {
+			t.Errorf("short=%t: got counter %d, want 2", short, seq)
+		}
+	}
+}
+
+func TestIterator(t *testing.T) {
+	s := NewSpace("prefix", nil)
+	next := s.Iterator(5)
+
+	seen := make(map[string]bool)
+	var ids []string
+	for id, ok := next(); ok; id, ok = next() {
+		if seen[id] {
+			t.Errorf("got duplicate ID %q", id)
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if len(ids) != 5 {
+		t.Fatalf("got %d IDs, want 5", len(ids))
+	}
+	for i, id := range ids {
+		if _, seq, ok := s.Parse(id); !ok || seq != i+1 {
+			t.Errorf("got counter %d of %q, want %d", seq, id, i+1)
+		}
+	}
+	if id, ok := next(); ok {
+		t.Errorf("got %q after draining, want none", id)
+	}
+}
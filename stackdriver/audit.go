@@ -0,0 +1,182 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// keyAuditLog is the key of the field returned by WithAuditLog. The Encoder adds the audit
+	// log fields to the entry itself rather than nesting them under the key.
+	keyAuditLog = "logging.googleapis.com/auditLog"
+
+	keyType = "@type"
+
+	// AuditLogType is the "@type" of the Cloud Audit Logs payload.
+	AuditLogType = "type.googleapis.com/google.cloud.audit.AuditLog"
+)
+
+// AuditLog represents the Cloud Audit Logs payload.
+//
+//  https://cloud.google.com/logging/docs/reference/audit/auditlog/rest/Shared.Types/AuditLog
+type AuditLog struct {
+	// The name of the API service performing the operation. For example, "compute.googleapis.com".
+	ServiceName string `json:"serviceName"`
+
+	// The name of the service method or operation. For example, "v1.compute.instances.insert".
+	MethodName string `json:"methodName"`
+
+	// The resource or collection that is the target of the operation.
+	ResourceName string `json:"resourceName"`
+
+	// Authentication information.
+	AuthenticationInfo *AuthenticationInfo `json:"authenticationInfo,omitempty"`
+
+	// Authorization information. If there are multiple resources or permissions involved,
+	// then there is one AuthorizationInfo element for each {resource, permission} tuple.
+	AuthorizationInfo []*AuthorizationInfo `json:"authorizationInfo,omitempty"`
+
+	// Metadata about the operation.
+	RequestMetadata *RequestMetadata `json:"requestMetadata,omitempty"`
+
+	// The status of the overall operation.
+	Status *AuditStatus `json:"status,omitempty"`
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (a *AuditLog) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString(keyType, AuditLogType)
+	enc.AddString("serviceName", a.ServiceName)
+	enc.AddString("methodName", a.MethodName)
+	enc.AddString("resourceName", a.ResourceName)
+	if a.AuthenticationInfo != nil {
+		if err := enc.AddObject("authenticationInfo", a.AuthenticationInfo); err != nil {
+			return err
+		}
+	}
+	if len(a.AuthorizationInfo) > 0 {
+		if err := enc.AddArray("authorizationInfo", authorizationInfos(a.AuthorizationInfo)); err != nil {
+			return err
+		}
+	}
+	if a.RequestMetadata != nil {
+		if err := enc.AddObject("requestMetadata", a.RequestMetadata); err != nil {
+			return err
+		}
+	}
+	if a.Status != nil {
+		if err := enc.AddObject("status", a.Status); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// inline implements inlineMarshaler.
+func (a *AuditLog) inline() {}
+
+// AuthenticationInfo represents the authentication information of the AuditLog.
+type AuthenticationInfo struct {
+	// The email address of the authenticated user making the request.
+	PrincipalEmail string `json:"principalEmail"`
+
+	// The authority selector specified by the requestor, if any.
+	AuthoritySelector string `json:"authoritySelector,omitempty"`
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (a *AuthenticationInfo) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("principalEmail", a.PrincipalEmail)
+	if a.AuthoritySelector != "" {
+		enc.AddString("authoritySelector", a.AuthoritySelector)
+	}
+
+	return nil
+}
+
+// AuthorizationInfo represents the authorization information of the AuditLog.
+type AuthorizationInfo struct {
+	// The resource being accessed, as a REST-style string.
+	Resource string `json:"resource"`
+
+	// The required IAM permission.
+	Permission string `json:"permission"`
+
+	// Whether or not authorization for resource and permission was granted.
+	Granted bool `json:"granted"`
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (a *AuthorizationInfo) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("resource", a.Resource)
+	enc.AddString("permission", a.Permission)
+	enc.AddBool("granted", a.Granted)
+
+	return nil
+}
+
+type authorizationInfos []*AuthorizationInfo
+
+// MarshalLogArray implements zapcore.ArrayMarshaler.
+func (as authorizationInfos) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, a := range as {
+		if err := enc.AppendObject(a); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RequestMetadata represents the metadata about the operation of the AuditLog.
+type RequestMetadata struct {
+	// The IP address of the caller.
+	CallerIP string `json:"callerIp"`
+
+	// The user agent of the caller.
+	CallerSuppliedUserAgent string `json:"callerSuppliedUserAgent,omitempty"`
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (r *RequestMetadata) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("callerIp", r.CallerIP)
+	if r.CallerSuppliedUserAgent != "" {
+		enc.AddString("callerSuppliedUserAgent", r.CallerSuppliedUserAgent)
+	}
+
+	return nil
+}
+
+// AuditStatus represents the status of the operation of the AuditLog.
+type AuditStatus struct {
+	// The status code, which should be an enum value of google.rpc.Code.
+	Code int `json:"code"`
+
+	// A developer-facing error message.
+	Message string `json:"message,omitempty"`
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (s *AuditStatus) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddInt("code", s.Code)
+	if s.Message != "" {
+		enc.AddString("message", s.Message)
+	}
+
+	return nil
+}
+
+// WithAuditLog adds the Cloud Audit Logs payload, marked by the "@type" of AuditLogType. The
+// audit log fields are added to the entry itself, as Stackdriver expects, rather than nested.
+func WithAuditLog(a *AuditLog) zapcore.Field {
+	if a == nil {
+		return zap.Skip()
+	}
+
+	return zap.Object(keyAuditLog, a)
+}
@@ -0,0 +1,76 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/zchee/zap-encoder/internal/testutil"
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+func TestWithAuditLog(t *testing.T) {
+	audit := &stackdriver.AuditLog{
+		ServiceName:        "storage.googleapis.com",
+		MethodName:         "storage.objects.delete",
+		ResourceName:       "projects/_/buckets/b/objects/o",
+		AuthenticationInfo: &stackdriver.AuthenticationInfo{PrincipalEmail: "user@example.com"},
+		AuthorizationInfo: []*stackdriver.AuthorizationInfo{
+			{Resource: "projects/_/buckets/b/objects/o", Permission: "storage.objects.delete", Granted: true},
+		},
+		RequestMetadata: &stackdriver.RequestMetadata{CallerIP: "192.0.2.1"},
+		Status:          &stackdriver.AuditStatus{Code: 0},
+	}
+	want := map[string]interface{}{
+		"@type":        "type.googleapis.com/google.cloud.audit.AuditLog",
+		"serviceName":  "storage.googleapis.com",
+		"methodName":   "storage.objects.delete",
+		"resourceName": "projects/_/buckets/b/objects/o",
+		"authenticationInfo": map[string]interface{}{
+			"principalEmail": "user@example.com",
+		},
+		"authorizationInfo": []interface{}{
+			map[string]interface{}{
+				"resource":   "projects/_/buckets/b/objects/o",
+				"permission": "storage.objects.delete",
+				"granted":    true,
+			},
+		},
+		"requestMetadata": map[string]interface{}{
+			"callerIp": "192.0.2.1",
+		},
+		"status": map[string]interface{}{
+			"code": float64(0),
+		},
+	}
+
+	for _, with := range []bool{false, true} {
+		lg := testutil.NewFakeLogger()
+		logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel)
+		if with {
+			logger.With(stackdriver.WithAuditLog(audit)).Info("msg")
+		} else {
+			logger.Info("msg", stackdriver.WithAuditLog(audit))
+		}
+
+		payload := decodePayload(t, lg.Entries()[0])
+		if _, ok := payload["logging.googleapis.com/auditLog"]; ok {
+			t.Errorf("with %t: the audit log is nested in the payload", with)
+		}
+		got := make(map[string]interface{}, len(want))
+		for k := range want {
+			if v, ok := payload[k]; ok {
+				got[k] = v
+			}
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("with %t: (-got, +want)\n%s", with, diff)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/exp/errors"
+)
+
+// ErrChannelFull is returned by the Write of the channel syncer with the DropWithError policy
+// when the channel is full.
+var ErrChannelFull = errors.New("stackdriver: channel full")
+
+// DropPolicy selects what the channel syncer does with a write when the channel is full.
+type DropPolicy int
+
+const (
+	// DropSilently discards the write and reports it as written.
+	DropSilently DropPolicy = iota
+	// DropWithError discards the write and returns ErrChannelFull, which zap reports to the
+	// ErrorOutput of the logger.
+	DropWithError
+)
+
+// ChannelOption configures the zapcore.WriteSyncer returned by NewChannelSyncer.
+type ChannelOption func(*channelSyncer)
+
+// WithDropPolicy sets the policy of the writes to the full channel. Defaults to DropSilently.
+func WithDropPolicy(policy DropPolicy) ChannelOption {
+	return func(ws *channelSyncer) {
+		ws.policy = policy
+	}
+}
+
+// channelSyncer is a zapcore.WriteSyncer which sends each write to ch.
+type channelSyncer struct {
+	ch     chan<- []byte
+	policy DropPolicy
+}
+
+//pragma: compiler time checks whether the channelSyncer implemented zapcore.WriteSyncer interface.
+var _ zapcore.WriteSyncer = (*channelSyncer)(nil)
+
+// NewChannelSyncer returns a zapcore.WriteSyncer which sends a copy of each encoded entry to ch,
+// e.g. to inspect the entries in the tests or to feed a custom pipeline. The writes never block,
+// the writes to the full channel are dropped by the DropPolicy. Sync is a no-op.
+func NewChannelSyncer(ch chan<- []byte, opts ...ChannelOption) zapcore.WriteSyncer {
+	ws := &channelSyncer{
+		ch: ch,
+	}
+	for _, opt := range opts {
+		opt(ws)
+	}
+
+	return ws
+}
+
+// Write implements zapcore.WriteSyncer.
+func (ws *channelSyncer) Write(b []byte) (int, error) {
+	// zap reuses b once Write returns.
+	p := make([]byte, len(b))
+	copy(p, b)
+
+	select {
+	case ws.ch <- p:
+	default:
+		if ws.policy == DropWithError {
+			return 0, ErrChannelFull
+		}
+	}
+
+	return len(b), nil
+}
+
+// Sync implements zapcore.WriteSyncer.
+func (ws *channelSyncer) Sync() error {
+	return nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/exp/errors"
+
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+func TestNewChannelSyncer(t *testing.T) {
+	ch := make(chan []byte, 2)
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), nil, stackdriver.NewStackdriverEncoderConfig())
+	logger := zap.New(zapcore.NewCore(enc, stackdriver.NewChannelSyncer(ch), zapcore.InfoLevel))
+
+	for _, msg := range []string{"first", "second", "dropped"} {
+		logger.Info(msg)
+	}
+	if err := logger.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+
+	var msgs []string
+	for b := range ch {
+		var payload map[string]interface{}
+		if err := json.Unmarshal(b, &payload); err != nil {
+			t.Fatalf("%q is not valid json: %+v", b, err)
+		}
+		msgs = append(msgs, payload["message"].(string))
+	}
+	if len(msgs) != 2 || msgs[0] != "first" || msgs[1] != "second" {
+		t.Errorf("got %q, want the first and second entries", msgs)
+	}
+}
+
+func TestNewChannelSyncerDropPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy stackdriver.DropPolicy
+		want   error
+	}{
+		{name: "silently", policy: stackdriver.DropSilently},
+		{name: "with error", policy: stackdriver.DropWithError, want: stackdriver.ErrChannelFull},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ch := make(chan []byte, 1)
+			ws := stackdriver.NewChannelSyncer(ch, stackdriver.WithDropPolicy(tt.policy))
+
+			b := []byte("entry")
+			if _, err := ws.Write(b); err != nil {
+				t.Fatal(err)
+			}
+			b[0] = 'E' // the channel has a copy.
+			if got := string(<-ch); got != "entry" {
+				t.Errorf("got %q, want %q", got, "entry")
+			}
+
+			ch <- []byte("full")
+			n, err := ws.Write([]byte("dropped"))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && n != len("dropped") {
+				t.Errorf("got %d bytes written, want %d", n, len("dropped"))
+			}
+			if got := string(<-ch); got != "full" {
+				t.Errorf("got %q, want %q", got, "full")
+			}
+		})
+	}
+}
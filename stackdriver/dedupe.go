@@ -0,0 +1,76 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// DedupePolicy selects which occurrence of a duplicated field key is kept.
+type DedupePolicy int
+
+const (
+	// DedupeLast keeps the last occurrence, e.g. the per-call field over the field added by With.
+	DedupeLast DedupePolicy = iota + 1
+	// DedupeFirst keeps the first occurrence, e.g. the field added by With over the per-call field.
+	DedupeFirst
+)
+
+// WithDedupeFields keeps only one occurrence of each field key in the payload, chosen by policy,
+// so the payload has no duplicated keys, which some JSON parsers reject. The keys are compared
+// within each namespace.
+//
+// The fields added by the zap.Logger.With are held by the Encoder and encoded with each entry
+// rather than encoded once.
+func WithDedupeFields(policy DedupePolicy) Option {
+	return func(o *options) {
+		o.dedupe = policy
+	}
+}
+
+// dedupeFields returns the fields with only one occurrence of each key in each namespace. The
+// namespace fields are always kept.
+func dedupeFields(fields []zapcore.Field, policy DedupePolicy) []zapcore.Field {
+	type scopedKey struct {
+		scope int
+		key   string
+	}
+
+	keep := make(map[scopedKey]int, len(fields)) // index of the kept occurrence
+	dup := false
+	scope := 0
+	for i, f := range fields {
+		if f.Type == zapcore.NamespaceType {
+			scope++
+			continue
+		}
+		k := scopedKey{scope: scope, key: f.Key}
+		if _, ok := keep[k]; ok {
+			dup = true
+			if policy == DedupeFirst {
+				continue
+			}
+		}
+		keep[k] = i
+	}
+	if !dup {
+		return fields
+	}
+
+	output := make([]zapcore.Field, 0, len(fields))
+	scope = 0
+	for i, f := range fields {
+		if f.Type == zapcore.NamespaceType {
+			scope++
+			output = append(output, f)
+			continue
+		}
+		if keep[scopedKey{scope: scope, key: f.Key}] == i {
+			output = append(output, f)
+		}
+	}
+
+	return output
+}
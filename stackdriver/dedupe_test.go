@@ -0,0 +1,67 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/zchee/zap-encoder/internal/testutil"
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+func TestEncoderWithDedupeFields(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []stackdriver.Option
+		wantCount int
+		want      string
+	}{
+		{name: "last", opts: []stackdriver.Option{stackdriver.WithDedupeFields(stackdriver.DedupeLast)}, wantCount: 1, want: "call"},
+		{name: "first", opts: []stackdriver.Option{stackdriver.WithDedupeFields(stackdriver.DedupeFirst)}, wantCount: 1, want: "with"},
+		{name: "disabled", wantCount: 2},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			lg := testutil.NewFakeLogger()
+			logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel, tt.opts...)
+
+			logger.With(zap.String("request", "with"), zap.Int("n", 1)).Info("msg",
+				zap.String("request", "call"),
+				zap.Namespace("nested"),
+				zap.String("request", "nested"),
+			)
+
+			entries := lg.Entries()
+			if len(entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(entries))
+			}
+			payload := entries[0].Payload.(string)
+			if got := strings.Count(payload, `"request":`); got != tt.wantCount+1 {
+				t.Fatalf("got %d request keys, want %d: %s", got, tt.wantCount+1, payload)
+			}
+			if tt.want == "" {
+				return
+			}
+
+			decoded := decodePayload(t, entries[0])
+			if got := decoded["request"]; got != tt.want {
+				t.Errorf("got request %v, want %s", got, tt.want)
+			}
+			if got := decoded["n"]; got != float64(1) {
+				t.Errorf("got n %v, want 1", got)
+			}
+			nested, _ := decoded["nested"].(map[string]interface{})
+			if got := nested["request"]; got != "nested" {
+				t.Errorf("got nested request %v, want nested", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"encoding/json"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// maxDepthMarker replaces the objects and arrays nested beyond the WithMaxObjectDepth.
+const maxDepthMarker = ellipsis + "(max depth)"
+
+// WithMaxObjectDepth stops descending into the object and array fields, including the reflected
+// ones, past the depth n, and replaces the deeper objects and arrays with "…(max depth)". The
+// value of a top-level field is at depth 1. A zero or negative n disables the limit.
+//
+// The reflected fields are marshaled into JSON and decoded before being limited, so the
+// recursive structures still fail to marshal.
+func WithMaxObjectDepth(n int) Option {
+	return func(o *options) {
+		o.maxObjectDepth = n
+	}
+}
+
+// limitDepth returns f whose object or array value is limited to the depth max.
+func limitDepth(f zapcore.Field, max int) zapcore.Field {
+	switch f.Type {
+	case zapcore.ObjectMarshalerType:
+		return zap.Object(f.Key, depthObjectMarshaler{obj: f.Interface.(zapcore.ObjectMarshaler), depth: 1, max: max})
+	case zapcore.ArrayMarshalerType:
+		return zap.Array(f.Key, depthArrayMarshaler{arr: f.Interface.(zapcore.ArrayMarshaler), depth: 1, max: max})
+	case zapcore.ReflectType:
+		v, ok := reflectedJSON(f.Interface)
+		if !ok {
+			return f
+		}
+		switch v := v.(type) {
+		case jsonObject:
+			return limitDepth(zap.Object(f.Key, v), max)
+		case jsonArray:
+			return limitDepth(zap.Array(f.Key, v), max)
+		}
+	}
+
+	return f
+}
+
+// limitFieldsDepth returns the fields whose values are limited to the depth max. The fields slice
+// is copied on the first change.
+func limitFieldsDepth(fields []zapcore.Field, max int) []zapcore.Field {
+	var output []zapcore.Field
+	for i, f := range fields {
+		switch f.Type {
+		case zapcore.ObjectMarshalerType, zapcore.ArrayMarshalerType, zapcore.ReflectType:
+		default:
+			continue
+		}
+		if output == nil {
+			output = make([]zapcore.Field, len(fields))
+			copy(output, fields)
+		}
+		output[i] = limitDepth(f, max)
+	}
+	if output == nil {
+		return fields
+	}
+
+	return output
+}
+
+// reflectedJSON returns v as the decoded JSON value, and reports whether v is marshaled
+// into a JSON object or array.
+func reflectedJSON(v interface{}) (interface{}, bool) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, false
+	}
+	value, err := decodeJSON(data)
+	if err != nil {
+		return nil, false
+	}
+	switch value.(type) {
+	case jsonObject, jsonArray:
+		return value, true
+	}
+
+	return nil, false
+}
+
+// depthObjectMarshaler marshals obj at the depth, replacing the objects and arrays nested
+// beyond max with the maxDepthMarker.
+type depthObjectMarshaler struct {
+	obj   zapcore.ObjectMarshaler
+	depth int
+	max   int
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (m depthObjectMarshaler) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	return m.obj.MarshalLogObject(&depthObjectEncoder{ObjectEncoder: enc, depth: m.depth, max: m.max})
+}
+
+// depthArrayMarshaler is the zapcore.ArrayMarshaler version of depthObjectMarshaler.
+type depthArrayMarshaler struct {
+	arr   zapcore.ArrayMarshaler
+	depth int
+	max   int
+}
+
+// MarshalLogArray implements zapcore.ArrayMarshaler.
+func (m depthArrayMarshaler) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	return m.arr.MarshalLogArray(&depthArrayEncoder{ArrayEncoder: enc, depth: m.depth, max: m.max})
+}
+
+type depthObjectEncoder struct {
+	zapcore.ObjectEncoder
+	depth int
+	max   int
+}
+
+// AddObject implements zapcore.ObjectEncoder.
+func (enc *depthObjectEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	if enc.depth >= enc.max {
+		enc.ObjectEncoder.AddString(key, maxDepthMarker)
+		return nil
+	}
+	return enc.ObjectEncoder.AddObject(key, depthObjectMarshaler{obj: obj, depth: enc.depth + 1, max: enc.max})
+}
+
+// AddArray implements zapcore.ObjectEncoder.
+func (enc *depthObjectEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	if enc.depth >= enc.max {
+		enc.ObjectEncoder.AddString(key, maxDepthMarker)
+		return nil
+	}
+	return enc.ObjectEncoder.AddArray(key, depthArrayMarshaler{arr: arr, depth: enc.depth + 1, max: enc.max})
+}
+
+// AddReflected implements zapcore.ObjectEncoder.
+func (enc *depthObjectEncoder) AddReflected(key string, value interface{}) error {
+	v, ok := reflectedJSON(value)
+	if !ok {
+		return enc.ObjectEncoder.AddReflected(key, value)
+	}
+	if obj, ok := v.(jsonObject); ok {
+		return enc.AddObject(key, obj)
+	}
+	return enc.AddArray(key, v.(jsonArray))
+}
+
+type depthArrayEncoder struct {
+	zapcore.ArrayEncoder
+	depth int
+	max   int
+}
+
+// AppendObject implements zapcore.ArrayEncoder.
+func (enc *depthArrayEncoder) AppendObject(obj zapcore.ObjectMarshaler) error {
+	if enc.depth >= enc.max {
+		enc.ArrayEncoder.AppendString(maxDepthMarker)
+		return nil
+	}
+	return enc.ArrayEncoder.AppendObject(depthObjectMarshaler{obj: obj, depth: enc.depth + 1, max: enc.max})
+}
+
+// AppendArray implements zapcore.ArrayEncoder.
+func (enc *depthArrayEncoder) AppendArray(arr zapcore.ArrayMarshaler) error {
+	if enc.depth >= enc.max {
+		enc.ArrayEncoder.AppendString(maxDepthMarker)
+		return nil
+	}
+	return enc.ArrayEncoder.AppendArray(depthArrayMarshaler{arr: arr, depth: enc.depth + 1, max: enc.max})
+}
+
+// AppendReflected implements zapcore.ArrayEncoder.
+func (enc *depthArrayEncoder) AppendReflected(value interface{}) error {
+	v, ok := reflectedJSON(value)
+	if !ok {
+		return enc.ArrayEncoder.AppendReflected(value)
+	}
+	if obj, ok := v.(jsonObject); ok {
+		return enc.AppendObject(obj)
+	}
+	return enc.AppendArray(v.(jsonArray))
+}
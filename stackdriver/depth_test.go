@@ -0,0 +1,81 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/zchee/zap-encoder/internal/testutil"
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+type node struct {
+	Name  string `json:"name"`
+	Child *node  `json:"child,omitempty"`
+}
+
+func (n *node) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("name", n.Name)
+	if n.Child != nil {
+		return enc.AddObject("child", n.Child)
+	}
+	return nil
+}
+
+func TestEncoderMaxObjectDepth(t *testing.T) {
+	tree := &node{Name: "1", Child: &node{Name: "2", Child: &node{Name: "3", Child: &node{Name: "4"}}}}
+	object := map[string]interface{}{
+		"name": "1",
+		"child": map[string]interface{}{
+			"name":  "2",
+			"child": "…(max depth)",
+		},
+	}
+
+	tests := []struct {
+		name  string
+		field zapcore.Field
+		want  interface{}
+	}{
+		{name: "object", field: zap.Object("tree", tree), want: object},
+		{name: "reflect", field: zap.Reflect("tree", tree), want: object},
+		{
+			name:  "array",
+			field: zap.Array("tree", arrayOf{tree}),
+			want:  []interface{}{map[string]interface{}{"name": "1", "child": "…(max depth)"}},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			lg := testutil.NewFakeLogger()
+			logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel, stackdriver.WithMaxObjectDepth(2))
+			logger.Info("msg", tt.field)
+			logger.With(tt.field).Info("with")
+
+			for _, entry := range lg.Entries() {
+				if got := decodePayload(t, entry)["tree"]; !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s: got %#v, want %#v", entry.Payload, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+type arrayOf []*node
+
+func (a arrayOf) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, n := range a {
+		if err := enc.AppendObject(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
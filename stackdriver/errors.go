@@ -0,0 +1,39 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"fmt"
+
+	"golang.org/x/exp/errors"
+)
+
+// MaxPayloadSize is the maximum size of the entry payload accepted by the Stackdriver API.
+//
+//  https://cloud.google.com/logging/quotas#log-limits
+const MaxPayloadSize = 256 << 10
+
+// ErrPayloadTooLarge is matched by the PayloadTooLargeError.
+var ErrPayloadTooLarge = errors.New("stackdriver: payload too large")
+
+// ErrSyncTimeout is returned by the Sync of the WriteSyncer if the entries are not delivered
+// within the timeout. See WithSyncTimeout.
+var ErrSyncTimeout = errors.New("stackdriver: sync timed out")
+
+// PayloadTooLargeError is the error for an entry whose payload exceeds MaxPayloadSize, which is
+// passed to the WithErrorHandler handler. The entry is not delivered to Stackdriver.
+type PayloadTooLargeError struct {
+	Size  int // size of the payload in bytes
+	Limit int
+}
+
+func (e *PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("%v: %d bytes exceeds the limit of %d bytes", ErrPayloadTooLarge, e.Size, e.Limit)
+}
+
+// Is reports whether target is ErrPayloadTooLarge.
+func (e *PayloadTooLargeError) Is(target error) bool {
+	return target == ErrPayloadTooLarge
+}
@@ -0,0 +1,76 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+// RateLimitErrorsWithClock is RateLimitErrors with the injectable clock.
+func RateLimitErrorsWithClock(fn func(error), interval time.Duration, now func() time.Time) func(error) {
+	return newErrorLimiter(fn, interval, now).handle
+}
+
+// SetTimeNow replaces the package clock with now, and returns the function which restores it.
+func SetTimeNow(now func() time.Time) (restore func()) {
+	timeNow = now
+	return func() {
+		timeNow = time.Now
+	}
+}
+
+// BuildInfoLabels returns the labels of WithBuildInfoLabels read by readBuildInfo.
+func BuildInfoLabels(readBuildInfo func() (*debug.BuildInfo, bool)) map[string]string {
+	return buildInfoLabels(readBuildInfo)
+}
+
+// ContextFunc returns the context function of the logging client configured by opts.
+func ContextFunc(ctx context.Context, opts ...ClientOption) func() (context.Context, func()) {
+	o := new(clientOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return newContextFunc(ctx, o)
+}
+
+// SetHostname replaces the host name resolver with fn, and returns the function which restores it.
+func SetHostname(fn func() (string, error)) (restore func()) {
+	orig := hostname
+	hostname = fn
+	return func() {
+		hostname = orig
+	}
+}
+
+// LogName returns the parent of the logging client and the log ID configured by opts.
+func LogName(projectID, logID string, opts ...ClientOption) (parent, id string) {
+	o := new(clientOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return resolveLogName(projectID, logID, o)
+}
+
+// DetectResourceWith returns the resource detected from the environment variables env and the
+// metadata values md, keyed by the metadata path, on GCE if onGCE is true.
+func DetectResourceWith(ctx context.Context, env map[string]string, onGCE bool, md map[string]string) *mrpb.MonitoredResource {
+	d := resourceDetector{
+		getenv: func(key string) string { return env[key] },
+		onGCE:  func() bool { return onGCE },
+		metadata: func(suffix string) (string, error) {
+			v, ok := md[suffix]
+			if !ok {
+				return "", fmt.Errorf("metadata %q not defined", suffix)
+			}
+			return v, nil
+		},
+	}
+	return d.detect(ctx)
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	sdlogging "cloud.google.com/go/logging"
+)
+
+// fallbackThreshold is the number of consecutive failed flushes after which the
+// delivery is considered persistently failing.
+const fallbackThreshold = 3
+
+// fallbackLogger is a Logger which writes the entry payloads as JSON lines to w
+// when lg is unavailable, or once the delivery through lg persistently fails.
+type fallbackLogger struct {
+	lg       Logger
+	failures int32 // atomic
+
+	mu sync.Mutex // guards w
+	w  io.Writer
+}
+
+//pragma: compiler time checks whether the fallbackLogger implemented Logger interface.
+var _ Logger = (*fallbackLogger)(nil)
+
+func newFallbackLogger(lg Logger, w io.Writer) *fallbackLogger {
+	return &fallbackLogger{
+		lg: lg,
+		w:  w,
+	}
+}
+
+func (l *fallbackLogger) fallenBack() bool {
+	return l.lg == nil || atomic.LoadInt32(&l.failures) >= fallbackThreshold
+}
+
+// Log implements Logger.
+func (l *fallbackLogger) Log(e sdlogging.Entry) {
+	if !l.fallenBack() {
+		l.lg.Log(e)
+		return
+	}
+
+	var line string
+	switch p := e.Payload.(type) {
+	case string:
+		line = p
+	default:
+		b, err := json.Marshal(p)
+		if err != nil {
+			return
+		}
+		line = string(b)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = io.WriteString(l.w, strings.TrimSuffix(line, "\n")+"\n")
+}
+
+// Flush implements Logger.
+//
+// Once the flush fails fallbackThreshold times in a row, counting the failures reported
+// by reportFailure meanwhile, the following entries are written to the fallback writer. The lock isn't held during the flush, so a slow flush
+// doesn't block the entries logged meanwhile.
+func (l *fallbackLogger) Flush() error {
+	if l.lg == nil {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if s, ok := l.w.(interface{ Sync() error }); ok {
+			return s.Sync()
+		}
+		return nil
+	}
+
+	err := l.lg.Flush()
+	if err != nil {
+		atomic.AddInt32(&l.failures, 1)
+		return err
+	}
+	// reset the failures in a row, unless the delivery already fell back for good.
+	if n := atomic.LoadInt32(&l.failures); n < fallbackThreshold {
+		atomic.CompareAndSwapInt32(&l.failures, n, 0)
+	}
+
+	return nil
+}
+
+// reportFailure counts the failed delivery reported by the logging client, e.g. the bundle
+// upload failed in the background, like a failed flush. See Encoder.OnError.
+func (l *fallbackLogger) reportFailure() {
+	if l.lg != nil {
+		atomic.AddInt32(&l.failures, 1)
+	}
+}
@@ -5,6 +5,9 @@
 package stackdriver
 
 import (
+	"context"
+	"sync"
+
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"golang.org/x/exp/errors"
@@ -37,10 +40,14 @@ type LogContext struct {
 	User           string          `json:"user"`
 	HTTPRequest    *HTTPRequest    `json:"httpRequest"`
 	ReportLocation *ReportLocation `json:"reportLocation"`
+
+	// Fields are the custom context fields, keyed by the name under the context.
+	// See WithContextKeys.
+	Fields []zapcore.Field `json:"-"`
 }
 
 func (lc *LogContext) IsEmpty() bool {
-	return lc.User == "" && lc.HTTPRequest == nil && lc.ReportLocation == nil
+	return lc.User == "" && lc.HTTPRequest == nil && lc.ReportLocation == nil && len(lc.Fields) == 0
 }
 
 func (lc *LogContext) Clone() *LogContext {
@@ -48,6 +55,11 @@ func (lc *LogContext) Clone() *LogContext {
 		User: lc.User,
 	}
 
+	if lc.Fields != nil {
+		output.Fields = make([]zapcore.Field, len(lc.Fields))
+		copy(output.Fields, lc.Fields)
+	}
+
 	if lc.HTTPRequest != nil {
 		output.HTTPRequest = lc.HTTPRequest.Clone()
 	}
@@ -76,9 +88,18 @@ func (lc *LogContext) MarshalLogObject(enc zapcore.ObjectEncoder) (err error) {
 		}
 	}
 
+	for _, f := range lc.Fields {
+		f.AddTo(enc)
+	}
+
 	return
 }
 
+// HTTPRequest is the HTTP request of the "context.httpRequest" field, which Error Reporting
+// shows with the error.
+//
+// Deprecated: Use HttpRequest, which covers the whole Stackdriver HttpRequest. The
+// "context.httpRequest" field also accepts an HttpRequest.
 type HTTPRequest struct {
 	Method             string `json:"method"`
 	URL                string `json:"url"`
@@ -100,6 +121,10 @@ func (req *HTTPRequest) Clone() *HTTPRequest {
 }
 
 func (req *HTTPRequest) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if req == nil {
+		return nil
+	}
+
 	enc.AddString("method", req.Method)
 	enc.AddString("url", req.URL)
 	enc.AddString("userAgent", req.UserAgent)
@@ -109,11 +134,45 @@ func (req *HTTPRequest) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	return nil
 }
 
-// LogHTTPPayload adds the correct Stackdriver "HttpRequest" field.
+// HttpRequest returns req as the HttpRequest. It returns nil if req is nil.
+func (req *HTTPRequest) HttpRequest() *HttpRequest {
+	if req == nil {
+		return nil
+	}
+
+	return &HttpRequest{
+		RequestMethod: req.Method,
+		RequestURL:    req.URL,
+		Status:        req.ResponseStatusCode,
+		UserAgent:     req.UserAgent,
+		RemoteIP:      req.RemoteIP,
+		Referer:       req.Referrer,
+	}
+}
+
+// httpRequestContext returns req in the shape of the "context.httpRequest" of Error Reporting.
+func httpRequestContext(req *HttpRequest) *HTTPRequest {
+	return &HTTPRequest{
+		Method:             req.RequestMethod,
+		URL:                req.RequestURL,
+		UserAgent:          req.UserAgent,
+		Referrer:           req.Referer,
+		ResponseStatusCode: req.Status,
+		RemoteIP:           req.RemoteIP,
+	}
+}
+
+// LogHTTPRequest adds the correct Stackdriver "HttpRequest" field, as LogHttpRequest does.
 //
 // ref: https://cloud.google.com/logging/docs/reference/v2/rest/v2/LogEntry#HttpRequest
+//
+// Deprecated: Use LogHttpRequest.
 func LogHTTPRequest(req *HTTPRequest) zap.Field {
-	return zap.Object(keyContextHTTPRequest, req)
+	if req == nil {
+		return zap.Skip()
+	}
+
+	return LogHttpRequest(req.HttpRequest())
 }
 
 type ReportLocation struct {
@@ -122,6 +181,25 @@ type ReportLocation struct {
 	FunctionName string `json:"functionName"`
 }
 
+// reportLocationPool pools the ReportLocations of the entries, which are only referenced until
+// the entry is encoded.
+var reportLocationPool = sync.Pool{
+	New: func() interface{} {
+		return new(ReportLocation)
+	},
+}
+
+// acquireReportLocation returns a zero ReportLocation from the pool.
+func acquireReportLocation() *ReportLocation {
+	return reportLocationPool.Get().(*ReportLocation)
+}
+
+// releaseReportLocation returns loc to the pool.
+func releaseReportLocation(loc *ReportLocation) {
+	*loc = ReportLocation{}
+	reportLocationPool.Put(loc)
+}
+
 func (r *ReportLocation) Clone() *ReportLocation {
 	return &ReportLocation{
 		FilePath:     r.FilePath,
@@ -131,6 +209,10 @@ func (r *ReportLocation) Clone() *ReportLocation {
 }
 
 func (r *ReportLocation) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if r == nil {
+		return nil
+	}
+
 	enc.AddString("filePath", r.FilePath)
 	enc.AddInt("lineNumber", r.LineNumber)
 	enc.AddString("functionName", r.FunctionName)
@@ -141,6 +223,78 @@ func WithContext(lc *LogContext) zapcore.Field {
 	return zap.Object(keyContext, lc)
 }
 
+// logContextKey is the context key of the LogContext stored by ContextWithLogContext.
+type logContextKey struct{}
+
+// ContextWithLogContext returns a copy of ctx which carries lc, e.g. set once by the middleware
+// for the request. See WithContextExtraction.
+func ContextWithLogContext(ctx context.Context, lc *LogContext) context.Context {
+	return context.WithValue(ctx, logContextKey{}, lc)
+}
+
+// WithContextExtraction applies the *LogContext stored under key in the context to the entries,
+// so the middleware sets it once and the entries logged in the request inherit it. The context
+// is the one of the WithRequestContext field of the entry or of the zap.Logger.With, or else the
+// one passed to NewStackdriverEncoder. The context fields of the entry override it. A nil key
+// reads the LogContext stored by ContextWithLogContext.
+func WithContextExtraction(key interface{}) Option {
+	if key == nil {
+		key = logContextKey{}
+	}
+
+	return func(o *options) {
+		o.logContextKey = key
+	}
+}
+
+// keyRequestContext is the key of the field returned by WithRequestContext, which is never
+// encoded.
+const keyRequestContext = "logging.googleapis.com/requestContext"
+
+// requestContext is the context carried by the field returned by WithRequestContext.
+type requestContext struct {
+	context.Context
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (requestContext) MarshalLogObject(zapcore.ObjectEncoder) error {
+	return nil
+}
+
+// WithRequestContext carries ctx, e.g. the context of the request, whose LogContext is applied
+// to the entry, or to every entry of the zap.Logger.With. See WithContextExtraction. The field
+// is dropped without WithContextExtraction, and it returns a no-op field if ctx is nil.
+func WithRequestContext(ctx context.Context) zapcore.Field {
+	if ctx == nil {
+		return zap.Skip()
+	}
+
+	return zap.Object(keyRequestContext, requestContext{ctx})
+}
+
+// asRequestContext returns the context of the field returned by WithRequestContext.
+func asRequestContext(f zapcore.Field) (context.Context, bool) {
+	if f.Key != keyRequestContext || f.Type != zapcore.ObjectMarshalerType {
+		return nil, false
+	}
+	rc, ok := f.Interface.(requestContext)
+
+	return rc.Context, ok
+}
+
+// logContextFrom returns a copy of the LogContext stored under key in ctx, or nil.
+func logContextFrom(ctx context.Context, key interface{}) *LogContext {
+	if ctx == nil || key == nil {
+		return nil
+	}
+	lc, ok := ctx.Value(key).(*LogContext)
+	if !ok || lc == nil {
+		return nil
+	}
+
+	return lc.Clone()
+}
+
 func WithServiceContext(sc *ServiceContext) zapcore.Field {
 	return zap.Object(keyServiceContext, sc)
 }
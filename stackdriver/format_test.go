@@ -0,0 +1,228 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/zchee/zap-encoder/internal/testutil"
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+func TestEncoderContext(t *testing.T) {
+	fields := []zapcore.Field{
+		stackdriver.WithUser("bob"),
+		zap.String("context.sessionId", "2c1b8d"),
+		zap.Int("context.attempt", 2),
+		zap.String("so", "passes"),
+	}
+
+	tests := []struct {
+		name string
+		opts []stackdriver.Option
+		want map[string]interface{}
+	}{
+		{
+			name: "known keys",
+			want: map[string]interface{}{
+				"context": map[string]interface{}{
+					"user": "bob",
+				},
+				"context.sessionId": "2c1b8d",
+				"context.attempt":   float64(2),
+				"so":                "passes",
+			},
+		},
+		{
+			name: "custom context keys",
+			opts: []stackdriver.Option{stackdriver.WithContextKeys("context.")},
+			want: map[string]interface{}{
+				"context": map[string]interface{}{
+					"user":      "bob",
+					"sessionId": "2c1b8d",
+					"attempt":   float64(2),
+				},
+				"so": "passes",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			lg := testutil.NewFakeLogger()
+			enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, zapcore.EncoderConfig{}, tt.opts...)
+			buf, err := enc.EncodeEntry(zapcore.Entry{}, fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer buf.Free()
+
+			if diff := cmp.Diff(decodePayload(t, lg.Entries()[0]), tt.want); diff != "" {
+				t.Errorf("(-got, +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+type userID int
+
+func (id userID) String() string { return fmt.Sprintf("user-%d", int(id)) }
+
+func TestEncoderContextStringer(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, zapcore.EncoderConfig{})
+
+	buf, err := enc.EncodeEntry(zapcore.Entry{}, []zapcore.Field{
+		zap.Stringer("context.user", userID(42)),
+		zap.Stringer("context.httpRequest", userID(7)),
+		zap.String("context.reportLocation", "not a location"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Free()
+
+	want := map[string]interface{}{
+		"context": map[string]interface{}{
+			"user": "user-42",
+		},
+		"context.httpRequest":    "user-7",
+		"context.reportLocation": "not a location",
+	}
+	if diff := cmp.Diff(decodePayload(t, lg.Entries()[0]), want); diff != "" {
+		t.Errorf("(-got, +want)\n%s", diff)
+	}
+}
+
+func TestEncoderContextNil(t *testing.T) {
+	tests := []zapcore.Field{
+		zap.Object("context.httpRequest", (*stackdriver.HTTPRequest)(nil)),
+		zap.Object("context.httpRequest", (*stackdriver.HttpRequest)(nil)),
+		zap.Object("context.reportLocation", (*stackdriver.ReportLocation)(nil)),
+	}
+	for _, f := range tests {
+		lg := testutil.NewFakeLogger()
+		enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, zapcore.EncoderConfig{})
+		buf, err := enc.EncodeEntry(zapcore.Entry{}, []zapcore.Field{f, stackdriver.WithUser("alice")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Free()
+
+		// the nil value is left to the JSON encoder, like the fields of any other type.
+		want := map[string]interface{}{
+			"context": map[string]interface{}{
+				"user": "alice",
+			},
+			f.Key: map[string]interface{}{},
+		}
+		if diff := cmp.Diff(decodePayload(t, lg.Entries()[0]), want); diff != "" {
+			t.Errorf("%T: (-got, +want)\n%s", f.Interface, diff)
+		}
+	}
+}
+
+type requestKey struct{}
+
+func TestEncoderContextExtraction(t *testing.T) {
+	lc := &stackdriver.LogContext{
+		User:        "alice",
+		HTTPRequest: &stackdriver.HTTPRequest{Method: "GET", URL: "/items"},
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		key  interface{}
+	}{
+		{name: "default key", ctx: stackdriver.ContextWithLogContext(context.Background(), lc)},
+		{name: "custom key", ctx: context.WithValue(context.Background(), requestKey{}, lc), key: requestKey{}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			lg := testutil.NewFakeLogger()
+			logger := stackdriver.NewLogger(tt.ctx, lg, zapcore.InfoLevel, stackdriver.WithContextExtraction(tt.key))
+			logger.Info("first")
+			logger.Info("second", stackdriver.WithUser("bob"))
+
+			entries := lg.Entries()
+			for i, user := range []string{"alice", "bob"} {
+				ctx, ok := decodePayload(t, entries[i])["context"].(map[string]interface{})
+				if !ok {
+					t.Fatalf("#%d: payload has no context", i)
+				}
+				if ctx["user"] != user {
+					t.Errorf("#%d: got user %v, want %q", i, ctx["user"], user)
+				}
+				if req, ok := ctx["httpRequest"].(map[string]interface{}); !ok || req["url"] != "/items" {
+					t.Errorf("#%d: got httpRequest %v, want the request of the context", i, ctx["httpRequest"])
+				}
+			}
+		})
+	}
+}
+
+func TestEncoderRequestContext(t *testing.T) {
+	alice := stackdriver.ContextWithLogContext(context.Background(), &stackdriver.LogContext{User: "alice"})
+	bob := stackdriver.ContextWithLogContext(context.Background(), &stackdriver.LogContext{User: "bob"})
+
+	lg := testutil.NewFakeLogger()
+	logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel, stackdriver.WithContextExtraction(nil))
+	logger.Info("first", stackdriver.WithRequestContext(alice))
+	logger.Info("second", stackdriver.WithRequestContext(bob))
+	logger.With(stackdriver.WithRequestContext(alice)).Info("third")
+	logger.With(stackdriver.WithRequestContext(alice)).Info("fourth", stackdriver.WithUser("carol"))
+	logger.Info("fifth", stackdriver.WithRequestContext(context.Background()))
+
+	entries := lg.Entries()
+	for i, user := range []string{"alice", "bob", "alice", "carol"} {
+		payload := decodePayload(t, entries[i])
+		ctx, ok := payload["context"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("#%d: payload has no context", i)
+		}
+		if ctx["user"] != user {
+			t.Errorf("#%d: got user %v, want %q", i, ctx["user"], user)
+		}
+		if got, ok := payload["logging.googleapis.com/requestContext"]; ok {
+			t.Errorf("#%d: got the request context %v in the payload", i, got)
+		}
+	}
+	payload := decodePayload(t, entries[4])
+	for _, key := range []string{"context", "logging.googleapis.com/requestContext"} {
+		if got, ok := payload[key]; ok {
+			t.Errorf("got %s %v, want none for the context without LogContext", key, got)
+		}
+	}
+}
+
+func TestEncoderContextHttpRequest(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, zapcore.EncoderConfig{})
+	buf, err := enc.EncodeEntry(zapcore.Entry{}, []zapcore.Field{
+		zap.Object("context.httpRequest", &stackdriver.HttpRequest{RequestMethod: "POST", RequestURL: "/orders", Status: 500}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Free()
+
+	ctx, _ := decodePayload(t, lg.Entries()[0])["context"].(map[string]interface{})
+	req, _ := ctx["httpRequest"].(map[string]interface{})
+	if req["method"] != "POST" || req["url"] != "/orders" || req["responseStatusCode"] != float64(500) {
+		t.Errorf("got context.httpRequest %v, want the Error Reporting shape of the request", ctx["httpRequest"])
+	}
+}
@@ -8,17 +8,29 @@ import (
 	"bytes"
 	"io"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	sdlogging "cloud.google.com/go/logging"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 const (
 	keyHTTPRequest = "httpRequest"
+
+	// keyNativeHTTPRequest is the key of the field returned by WithNativeHTTPRequest. The
+	// Encoder delivers the request with the entry instead of encoding the field.
+	keyNativeHTTPRequest = "logging.googleapis.com/httpRequest"
 )
 
-// HttpRequest represents a common proto for logging HTTP requests.
+// timeNow returns the current local time. It is replaced in tests.
+var timeNow = time.Now
+
+// HttpRequest represents a common proto for logging HTTP requests. It is the request type of
+// the package; the HTTPRequest is deprecated in favor of it.
 //
 // Only contains semantics defined by the HTTP specification.
 // Product-specific logging information MUST be defined in a separate message.
@@ -92,6 +104,11 @@ type HttpRequest struct {
 	//
 	// Examples: "HTTP/1.1", "HTTP/2", "websocket"
 	Protocol string `json:"protocol"`
+
+	// The allow-listed response headers, keyed by the canonical header name.
+	// It is not a part of the Stackdriver HttpRequest and only appears in the
+	// JSON payload.
+	ResponseHeaders map[string]string `json:"responseHeaders,omitempty"`
 }
 
 // Clone implements zapcore.Encoder.
@@ -112,19 +129,24 @@ func (req *HttpRequest) Clone() *HttpRequest {
 		CacheValidatedWithOriginServer: req.CacheValidatedWithOriginServer,
 		CacheFillBytes:                 req.CacheFillBytes,
 		Protocol:                       req.Protocol,
+		ResponseHeaders:                cloneHeaders(req.ResponseHeaders),
 	}
 }
 
 // MarshalLogObject implements zapcore.ObjectMarshaler.
 func (req *HttpRequest) MarshalLogObject(enc zapcore.ObjectEncoder) error {
-	enc.AddReflected("requestMethod", req.RequestMethod)
-	enc.AddString("requestURL", req.RequestURL)
+	if req == nil {
+		return nil
+	}
+
+	enc.AddString("requestMethod", req.RequestMethod)
+	enc.AddString("requestUrl", req.RequestURL)
 	enc.AddString("requestSize", req.RequestSize)
 	enc.AddInt("status", req.Status)
 	enc.AddString("responseSize", req.ResponseSize)
 	enc.AddString("userAgent", req.UserAgent)
-	enc.AddString("remoteIP", req.RemoteIP)
-	enc.AddString("serverIP", req.ServerIP)
+	enc.AddString("remoteIp", req.RemoteIP)
+	enc.AddString("serverIp", req.ServerIP)
 	enc.AddString("referer", req.Referer)
 	enc.AddString("latency", req.Latency)
 	enc.AddBool("cacheLookup", req.CacheLookup)
@@ -132,18 +154,92 @@ func (req *HttpRequest) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddBool("cacheValidatedWithOriginServer", req.CacheValidatedWithOriginServer)
 	enc.AddString("cacheFillBytes", req.CacheFillBytes)
 	enc.AddString("protocol", req.Protocol)
+	if len(req.ResponseHeaders) > 0 {
+		if err := enc.AddObject("responseHeaders", stringMap(req.ResponseHeaders)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stringMap represents a string map, e.g. the HTTP headers, which marshals in sorted key order.
+type stringMap map[string]string
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (h stringMap) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		enc.AddString(k, h[k])
+	}
 
 	return nil
 }
 
-// LogHttpRequest adds the correct Stackdriver "HttpRequest" field.
+func cloneHeaders(h map[string]string) map[string]string {
+	if h == nil {
+		return nil
+	}
+
+	output := make(map[string]string, len(h))
+	for k, v := range h {
+		output[k] = v
+	}
+
+	return output
+}
+
+// LogHttpRequest adds the correct Stackdriver "HttpRequest" field. The keys of the field are
+// the JSON names of the Stackdriver HttpRequest, e.g. "requestUrl".
 func LogHttpRequest(req *HttpRequest) zap.Field {
 	return zap.Object(keyHTTPRequest, req)
 }
 
+// LogHTTPRequestTimed adds the correct Stackdriver "HttpRequest" field, with the Latency
+// set to the elapsed time since start.
+//
+// req is not modified. It returns a no-op field if req is nil.
+func LogHTTPRequestTimed(req *HttpRequest, start time.Time) zap.Field {
+	if req == nil {
+		return zap.Skip()
+	}
+
+	r := req.Clone()
+	r.Latency = formatLatency(timeNow().Sub(start))
+
+	return LogHttpRequest(r)
+}
+
+// formatLatency formats d as the Stackdriver duration, in seconds with up to
+// nine fractional digits, terminated by 's'.
+func formatLatency(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+
+	sec := strconv.FormatInt(int64(d/time.Second), 10)
+	nsec := int64(d % time.Second)
+	if nsec == 0 {
+		return sec + "s"
+	}
+
+	frac := strconv.FormatInt(nsec, 10)
+	frac = strings.Repeat("0", 9-len(frac)) + frac
+
+	return sec + "." + strings.TrimRight(frac, "0") + "s"
+}
+
 // NewHttpRequest returns a new HttpRequest struct, based on the passed
 // in http.Request and http.Response objects.
-func NewHHttpRequest(req *http.Request, resp *http.Response) *HttpRequest {
+//
+// Only the response headers named in allowHeaders are attached to the ResponseHeaders,
+// so that the sensitive headers are never logged by accident.
+func NewHHttpRequest(req *http.Request, resp *http.Response, allowHeaders ...string) *HttpRequest {
 	if req == nil {
 		req = &http.Request{}
 	}
@@ -176,5 +272,82 @@ func NewHHttpRequest(req *http.Request, resp *http.Response) *HttpRequest {
 		r.ResponseSize = strconv.FormatInt(n, 10)
 	}
 
+	for _, name := range allowHeaders {
+		vals, ok := resp.Header[http.CanonicalHeaderKey(name)]
+		if !ok {
+			continue
+		}
+		if r.ResponseHeaders == nil {
+			r.ResponseHeaders = make(map[string]string)
+		}
+		r.ResponseHeaders[http.CanonicalHeaderKey(name)] = strings.Join(vals, ", ")
+	}
+
 	return r
 }
+
+// nativeHTTPRequest is the ObjectMarshaler of the field returned by WithNativeHTTPRequest.
+type nativeHTTPRequest struct {
+	*sdlogging.HTTPRequest
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (req nativeHTTPRequest) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("requestMethod", req.Request.Method)
+	enc.AddString("requestUrl", req.Request.URL.String())
+	enc.AddInt("status", req.Status)
+	enc.AddString("latency", formatLatency(req.Latency))
+
+	return nil
+}
+
+// WithNativeHTTPRequest sets the HTTP request of the entry delivered to Stackdriver, which
+// shows it in the entry summary and derives the trace from its X-Cloud-Trace-Context header
+// unless the entry has a trace. See LoggingMiddleware.
+//
+// The request is delivered with the entry rather than encoded in the payload. It returns a
+// no-op field if req or req.Request is nil, which the logging client would panic on.
+func WithNativeHTTPRequest(req *sdlogging.HTTPRequest) zapcore.Field {
+	if req == nil || req.Request == nil {
+		return zap.Skip()
+	}
+
+	return zap.Object(keyNativeHTTPRequest, nativeHTTPRequest{req})
+}
+
+// extractNativeHTTPRequest removes the fields returned by WithNativeHTTPRequest, and returns
+// the last request found.
+func extractNativeHTTPRequest(fields []zapcore.Field) ([]zapcore.Field, *sdlogging.HTTPRequest) {
+	var (
+		output []zapcore.Field
+		req    *sdlogging.HTTPRequest
+	)
+	for i, f := range fields {
+		r, ok := asNativeHTTPRequest(f)
+		if !ok {
+			if output != nil {
+				output = append(output, f)
+			}
+			continue
+		}
+		if output == nil {
+			output = make([]zapcore.Field, i, len(fields))
+			copy(output, fields[:i])
+		}
+		req = r
+	}
+	if output == nil {
+		return fields, nil
+	}
+
+	return output, req
+}
+
+func asNativeHTTPRequest(f zapcore.Field) (*sdlogging.HTTPRequest, bool) {
+	if f.Key != keyNativeHTTPRequest || f.Type != zapcore.ObjectMarshalerType {
+		return nil, false
+	}
+	r, ok := f.Interface.(nativeHTTPRequest)
+
+	return r.HTTPRequest, ok
+}
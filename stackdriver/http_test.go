@@ -0,0 +1,141 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/zchee/zap-encoder/internal/testutil"
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+func TestLogHTTPRequestTimed(t *testing.T) {
+	start := time.Date(2018, 6, 19, 16, 33, 42, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		want    string
+	}{
+		{name: "whole seconds", elapsed: 3 * time.Second, want: "3s"},
+		{name: "fractional seconds", elapsed: 3500 * time.Millisecond, want: "3.5s"},
+		{name: "nanoseconds", elapsed: 1*time.Second + 20*time.Nanosecond, want: "1.00000002s"},
+		{name: "sub second", elapsed: 250 * time.Microsecond, want: "0.00025s"},
+		{name: "clock skew", elapsed: -time.Second, want: "0s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restore := stackdriver.SetTimeNow(func() time.Time { return start.Add(tt.elapsed) })
+			defer restore()
+
+			lg := testutil.NewFakeLogger()
+			enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig())
+
+			req := &stackdriver.HttpRequest{RequestMethod: "GET", Status: 200}
+			buf, err := enc.EncodeEntry(zapcore.Entry{Message: "request"}, []zapcore.Field{stackdriver.LogHTTPRequestTimed(req, start)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer buf.Free()
+
+			got := decodePayload(t, lg.Entries()[0])["httpRequest"].(map[string]interface{})
+			if got["latency"] != tt.want {
+				t.Errorf("got latency %v, want %s", got["latency"], tt.want)
+			}
+			if req.Latency != "" {
+				t.Errorf("req was modified: %+v", req)
+			}
+		})
+	}
+}
+
+func TestLogHTTPRequestTimedNil(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig())
+
+	buf, err := enc.EncodeEntry(zapcore.Entry{Message: "request"}, []zapcore.Field{stackdriver.LogHTTPRequestTimed(nil, time.Now())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Free()
+
+	if got, ok := decodePayload(t, lg.Entries()[0])["httpRequest"]; ok {
+		t.Errorf("got httpRequest %v, want none", got)
+	}
+}
+
+func TestNewHttpRequestResponseHeaders(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/some/info?color=red", nil)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control": {"public", "max-age=60"},
+			"Etag":          {`"33a64df5"`},
+			"Set-Cookie":    {"session=secret"},
+		},
+	}
+
+	r := stackdriver.NewHHttpRequest(req, resp, "cache-control", "ETag", "X-Missing")
+
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig())
+	buf, err := enc.EncodeEntry(zapcore.Entry{Message: "request"}, []zapcore.Field{stackdriver.LogHttpRequest(r)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Free()
+
+	got := decodePayload(t, lg.Entries()[0])["httpRequest"].(map[string]interface{})["responseHeaders"]
+	want := map[string]interface{}{
+		"Cache-Control": "public, max-age=60",
+		"Etag":          `"33a64df5"`,
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("responseHeaders: (-got, +want)\n%s", diff)
+	}
+
+	if r := stackdriver.NewHHttpRequest(req, resp); r.ResponseHeaders != nil {
+		t.Errorf("got response headers %v without allow-list", r.ResponseHeaders)
+	}
+}
+
+func TestLogHTTPRequestUnified(t *testing.T) {
+	fields := []zapcore.Field{
+		stackdriver.LogHttpRequest(&stackdriver.HttpRequest{RequestMethod: "GET", RequestURL: "/items", Status: 200, RemoteIP: "192.0.2.1", Referer: "/"}),
+		stackdriver.LogHTTPRequest(&stackdriver.HTTPRequest{Method: "GET", URL: "/items", ResponseStatusCode: 200, RemoteIP: "192.0.2.1", Referrer: "/"}),
+	}
+
+	var got []interface{}
+	for _, f := range fields {
+		lg := testutil.NewFakeLogger()
+		enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig())
+		buf, err := enc.EncodeEntry(zapcore.Entry{Message: "request"}, []zapcore.Field{f})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Free()
+		got = append(got, decodePayload(t, lg.Entries()[0])["httpRequest"])
+	}
+
+	req, ok := got[0].(map[string]interface{})
+	if !ok {
+		t.Fatalf("got httpRequest %v, want an object", got[0])
+	}
+	for key, want := range map[string]interface{}{"requestMethod": "GET", "requestUrl": "/items", "remoteIp": "192.0.2.1"} {
+		if req[key] != want {
+			t.Errorf("got %s %v, want %v", key, req[key], want)
+		}
+	}
+	if diff := cmp.Diff(got[0], got[1]); diff != "" {
+		t.Errorf("LogHttpRequest and LogHTTPRequest differ: (-LogHttpRequest, +LogHTTPRequest)\n%s", diff)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// inlineMarshaler is an ObjectMarshaler whose fields the Encoder adds to the entry itself
+// rather than nesting them under the field key, e.g. the AuditLog.
+type inlineMarshaler interface {
+	zapcore.ObjectMarshaler
+	inline()
+}
+
+// marshalInline marshals the inline fields into enc, and returns the rest of the fields. As the
+// zapcore.Field does, the marshal error of a field is added as the "<key>Error" field.
+func marshalInline(enc zapcore.ObjectEncoder, fields []zapcore.Field) []zapcore.Field {
+	var output []zapcore.Field
+	for i, f := range fields {
+		m, ok := asInline(f)
+		if !ok {
+			if output != nil {
+				output = append(output, f)
+			}
+			continue
+		}
+		if output == nil {
+			output = make([]zapcore.Field, i, len(fields))
+			copy(output, fields[:i])
+		}
+		if err := m.MarshalLogObject(enc); err != nil {
+			output = append(output, zap.String(f.Key+"Error", err.Error()))
+		}
+	}
+	if output == nil {
+		return fields
+	}
+
+	return output
+}
+
+func asInline(f zapcore.Field) (inlineMarshaler, bool) {
+	if f.Type != zapcore.ObjectMarshalerType {
+		return nil, false
+	}
+	m, ok := f.Interface.(inlineMarshaler)
+
+	return m, ok
+}
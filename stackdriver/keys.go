@@ -0,0 +1,152 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// WithFieldKeyTransformer applies transform to the key of every field, e.g. to normalize the
+// camelCase keys of the third-party libraries to snake_case. The keys of the nested objects
+// are left as is, and so are the reserved Stackdriver keys: the "logging.googleapis.com/"
+// special fields, "serviceContext", and "context" and the "context." fields.
+func WithFieldKeyTransformer(transform func(string) string) Option {
+	return func(o *options) {
+		o.keyTransformer = transform
+	}
+}
+
+// transformKey returns key transformed by WithFieldKeyTransformer.
+func (e *Encoder) transformKey(key string) string {
+	if e.opts.keyTransformer == nil || isReservedKey(key, e.opts.contextPrefix) {
+		return key
+	}
+
+	return e.opts.keyTransformer(key)
+}
+
+// transformKeys returns the fields with the keys transformed by WithFieldKeyTransformer. The
+// fields slice is copied before modification so the caller's fields are left untouched.
+func (e *Encoder) transformKeys(fields []zapcore.Field) []zapcore.Field {
+	if e.opts.keyTransformer == nil {
+		return fields
+	}
+
+	var output []zapcore.Field
+	for i, f := range fields {
+		key := e.transformKey(f.Key)
+		if key == f.Key {
+			continue
+		}
+		if output == nil {
+			output = make([]zapcore.Field, len(fields))
+			copy(output, fields)
+		}
+		output[i].Key = key
+	}
+	if output == nil {
+		return fields
+	}
+
+	return output
+}
+
+// WithFieldAllowlist drops the top-level fields whose key isn't in keys, e.g. to deliver only
+// the fields of a strict schema and keep the accidental PII out of Stackdriver. The keys are
+// matched after WithFieldKeyTransformer, and the reserved Stackdriver keys are always allowed.
+// The fields nested in the objects and allowed namespaces are left as is, and the fields nested
+// in a namespace whose key isn't in keys are dropped with it.
+func WithFieldAllowlist(keys []string) Option {
+	allowlist := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		allowlist[k] = true
+	}
+
+	return func(o *options) {
+		o.fieldAllowlist = allowlist
+	}
+}
+
+// allowedKey reports whether the field of key is allowed by WithFieldAllowlist. Only the
+// Stackdriver entry fields are allowed in the namespace dropped by the allowlist.
+func (e *Encoder) allowedKey(key string) bool {
+	if e.opts.fieldAllowlist == nil {
+		return true
+	}
+	if e.droppedNamespace {
+		return isEntryKey(key)
+	}
+	if e.namespaced {
+		return true
+	}
+
+	return e.opts.fieldAllowlist[key] || isReservedKey(key, e.opts.contextPrefix)
+}
+
+// allowFields returns the fields allowed by WithFieldAllowlist. The fields following an allowed
+// namespace are nested in it, and are kept. The fields following a disallowed namespace are
+// dropped with it, except the Stackdriver entry fields.
+func (e *Encoder) allowFields(fields []zapcore.Field) []zapcore.Field {
+	if e.opts.fieldAllowlist == nil || e.namespaced && !e.droppedNamespace {
+		return fields
+	}
+
+	var (
+		output  []zapcore.Field
+		dropped bool
+	)
+	for i, f := range fields {
+		allowed := e.allowedKey(f.Key)
+		if dropped {
+			allowed = isEntryKey(f.Key)
+		}
+		if f.Type == zapcore.NamespaceType {
+			if allowed && !e.droppedNamespace && !dropped {
+				if output != nil {
+					output = append(output, fields[i:]...)
+				}
+				break
+			}
+			dropped, allowed = true, false
+		}
+		if allowed {
+			if output != nil {
+				output = append(output, f)
+			}
+			continue
+		}
+		if output == nil {
+			output = make([]zapcore.Field, i, len(fields))
+			copy(output, fields[:i])
+		}
+	}
+	if output == nil {
+		return fields
+	}
+
+	return output
+}
+
+// isEntryKey reports whether key is the Stackdriver "logging.googleapis.com/" field, which is
+// delivered with the entry or has the meaning for Stackdriver.
+func isEntryKey(key string) bool {
+	return strings.HasPrefix(key, "logging.googleapis.com/")
+}
+
+// isReservedKey reports whether key has the meaning for Stackdriver, or for the Encoder.
+func isReservedKey(key, contextPrefix string) bool {
+	switch {
+	case isEntryKey(key):
+		return true
+	case key == keyServiceContext, key == keyContext, strings.HasPrefix(key, keyContext+"."):
+		return true
+	case contextPrefix != "" && strings.HasPrefix(key, contextPrefix):
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,147 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/zchee/zap-encoder/internal/testutil"
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+func snakeCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func TestEncoderWithFieldKeyTransformer(t *testing.T) {
+	for _, dedupe := range []bool{false, true} {
+		opts := []stackdriver.Option{stackdriver.WithFieldKeyTransformer(snakeCase)}
+		if dedupe {
+			opts = append(opts, stackdriver.WithDedupeFields(stackdriver.DedupeLast))
+		}
+		lg := testutil.NewFakeLogger()
+		logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel, opts...)
+
+		logger.With(zap.String("userAgent", "curl")).Info("msg",
+			zap.String("requestId", "abc"),
+			stackdriver.WithUser("gopher"),
+			stackdriver.WithSpanID("000000000000004a"),
+			zap.Object("httpInfo", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddInt("statusCode", 200)
+				return nil
+			})),
+		)
+
+		payload := decodePayload(t, lg.Entries()[0])
+		for key, want := range map[string]interface{}{
+			"request_id":                    "abc",
+			"user_agent":                    "curl",
+			"severity":                      "INFO",
+			"logging.googleapis.com/spanId": "000000000000004a",
+		} {
+			if got := payload[key]; got != want {
+				t.Errorf("dedupe %t: got %s %v, want %v", dedupe, key, got, want)
+			}
+		}
+		for _, key := range []string{"requestId", "userAgent"} {
+			if _, ok := payload[key]; ok {
+				t.Errorf("dedupe %t: got untransformed key %s", dedupe, key)
+			}
+		}
+		if ctx, _ := payload["context"].(map[string]interface{}); ctx["user"] != "gopher" {
+			t.Errorf("dedupe %t: got context %v, want the user", dedupe, payload["context"])
+		}
+		if info, _ := payload["http_info"].(map[string]interface{}); info["statusCode"] != float64(200) {
+			t.Errorf("dedupe %t: got http_info %v, want the nested keys untouched", dedupe, payload["http_info"])
+		}
+	}
+}
+
+func TestEncoderWithFieldAllowlist(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel,
+		stackdriver.WithFieldAllowlist([]string{"orderId", "amount", "details"}))
+	logger.With(zap.String("orderId", "o-1"), zap.String("email", "bob@example.com")).Info("paid",
+		zap.Int("amount", 42),
+		zap.String("cardNumber", "4111111111111111"),
+		stackdriver.WithUser("bob"),
+		zap.Any("details", map[string]string{"note": "nested fields are kept"}),
+	)
+
+	payload := decodePayload(t, lg.Entries()[0])
+	for _, key := range []string{"orderId", "amount", "details", "context", "message"} {
+		if _, ok := payload[key]; !ok {
+			t.Errorf("allowed %q is missing: %v", key, payload)
+		}
+	}
+	for _, key := range []string{"email", "cardNumber"} {
+		if _, ok := payload[key]; ok {
+			t.Errorf("disallowed %q is delivered: %v", key, payload)
+		}
+	}
+	if details, _ := payload["details"].(map[string]interface{}); details["note"] == nil {
+		t.Errorf("got details %v, want the nested note", payload["details"])
+	}
+}
+
+func TestEncoderWithFieldAllowlistNamespace(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel,
+		stackdriver.WithFieldAllowlist([]string{"ok", "order"}))
+	logger.Info("msg", zap.String("ok", "1"), zap.Namespace("pii"), zap.String("ssn", "123"))
+	logger.Info("msg", zap.Namespace("order"), zap.String("id", "o-1"))
+	logger.With(zap.String("ok", "2"), zap.Namespace("pii2"), zap.String("ssn2", "456")).Info("msg",
+		zap.String("ssn3", "789"),
+		stackdriver.WithLabels(map[string]string{"env": "prod"}),
+	)
+	logger.With(zap.Namespace("order")).Info("msg", zap.String("id", "o-2"))
+
+	entries := lg.Entries()
+	if len(entries) != 4 {
+		t.Fatalf("got %d entries, want 4", len(entries))
+	}
+	tests := []struct {
+		want    map[string]interface{}
+		dropped []string
+	}{
+		{want: map[string]interface{}{"ok": "1"}, dropped: []string{"pii", "ssn"}},
+		{want: map[string]interface{}{"order": map[string]interface{}{"id": "o-1"}}},
+		{want: map[string]interface{}{"ok": "2"}, dropped: []string{"pii2", "ssn2", "ssn3"}},
+		{want: map[string]interface{}{"order": map[string]interface{}{"id": "o-2"}}},
+	}
+	for i, tt := range tests {
+		payload := decodePayload(t, entries[i])
+		for key, v := range tt.want {
+			if diff := cmp.Diff(payload[key], v); diff != "" {
+				t.Errorf("#%d: %s: (-got, +want)\n%s", i, key, diff)
+			}
+		}
+		for _, key := range tt.dropped {
+			if _, ok := payload[key]; ok {
+				t.Errorf("#%d: disallowed %q is delivered: %v", i, key, payload)
+			}
+		}
+	}
+	if got := entries[2].Labels["env"]; got != "prod" {
+		t.Errorf("got label env %q under the dropped namespace, want %q", got, "prod")
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"os"
+	"runtime/debug"
+	"sort"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	labelGoVersion     = "go_version"
+	labelModuleVersion = "module_version"
+	labelVCSRevision   = "vcs.revision"
+
+	// labelTruncated marks the entries whose labels were truncated or dropped by the label limits.
+	labelTruncated = "labels_truncated"
+
+	// keyLabels is the key of the special payload field, which Stackdriver promotes to the labels
+	// of the entry.
+	keyLabels = "logging.googleapis.com/labels"
+)
+
+// The Stackdriver limits of the labels, which are enforced by default. See WithLabelLimits.
+//
+//  https://cloud.google.com/logging/quotas#log-limits
+const (
+	DefaultMaxLabels           = 64
+	DefaultMaxLabelKeyLength   = 512
+	DefaultMaxLabelValueLength = 64 << 10
+)
+
+// labelLimits holds the limits of the labels of an entry. A zero or negative limit is disabled.
+type labelLimits struct {
+	count       int
+	keyLength   int
+	valueLength int
+}
+
+var defaultLabelLimits = labelLimits{
+	count:       DefaultMaxLabels,
+	keyLength:   DefaultMaxLabelKeyLength,
+	valueLength: DefaultMaxLabelValueLength,
+}
+
+// WithLabelLimits sets the maximum number of labels per entry, and the maximum length in bytes of
+// the label keys and values, since Stackdriver fails the whole write if they are exceeded. The
+// longer keys and values are truncated, and the excess labels are dropped in the key order, in
+// which case the entry has the "labels_truncated" label, which counts toward maxCount. A zero or
+// negative limit disables it. Defaults to the Stackdriver limits.
+func WithLabelLimits(maxCount, maxKeyLength, maxValueLength int) Option {
+	return func(o *options) {
+		o.labelLimits = labelLimits{
+			count:       maxCount,
+			keyLength:   maxKeyLength,
+			valueLength: maxValueLength,
+		}
+	}
+}
+
+// WithBuildInfoLabels adds the "go_version", "module_version" and "vcs.revision" labels,
+// read once from the build information embedded in the binary, to every entry. The
+// labels which aren't available, e.g. the VCS revision of a test binary, are omitted.
+func WithBuildInfoLabels() Option {
+	labels := buildInfoLabels(debug.ReadBuildInfo)
+
+	return func(o *options) {
+		o.labels = mergeLabels(o.labels, labels)
+	}
+}
+
+// hostname returns the host name. It is replaced in tests.
+var hostname = os.Hostname
+
+// WithHostnameLabel adds the host name as the label named key to every entry. The host name is
+// resolved once when the Encoder is created, rather than per entry, and fallback is used if it
+// can't be resolved.
+func WithHostnameLabel(key, fallback string) Option {
+	return func(o *options) {
+		name, err := hostname()
+		if err != nil || name == "" {
+			name = fallback
+		}
+		o.labels = mergeLabels(o.labels, map[string]string{key: name})
+	}
+}
+
+// WithLoggerNameLabel copies the logger name of each entry into the label named key,
+// e.g. "logger=payments", so the entries can be filtered by subsystem. The entries of
+// the unnamed loggers don't have the label.
+func WithLoggerNameLabel(key string) Option {
+	return func(o *options) {
+		o.loggerNameLabel = key
+	}
+}
+
+// WithPayloadLabels adds the "logging.googleapis.com/labels" special field, which Stackdriver
+// promotes to the labels of the entry. See WithPromotePayloadLabels.
+func WithPayloadLabels(labels map[string]string) zapcore.Field {
+	return zap.Object(keyLabels, stringMap(labels))
+}
+
+// WithPromotePayloadLabels moves the labels of the "logging.googleapis.com/labels" fields, e.g.
+// the ones returned by WithPayloadLabels or zap.Any with a map[string]string, from the payload to
+// the labels of the entry delivered to Stackdriver, where the label limits apply to them. They
+// override the static labels, e.g. of WithBuildInfoLabels, but not the WithLoggerNameLabel.
+// Without it, the fields are passed through as is.
+func WithPromotePayloadLabels(enable bool) Option {
+	return func(o *options) {
+		o.promoteLabels = enable
+	}
+}
+
+// WithLabels adds labels to the labels of the entry delivered to Stackdriver, where they are
+// indexed, rather than to the payload. The labels added by the zap.Logger.With are merged with
+// the ones of each entry, which win on the key collisions. See WithPromotePayloadLabels for the
+// precedence over the other labels.
+func WithLabels(labels map[string]string) zapcore.Field {
+	return zap.Object(keyLabels, labelsField(mergeLabels(nil, labels)))
+}
+
+// labelsField is the ObjectMarshaler of the field returned by WithLabels, which is always
+// promoted to the labels of the entry.
+type labelsField map[string]string
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (m labelsField) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	return stringMap(m).MarshalLogObject(enc)
+}
+
+// asPayloadLabels returns the labels of the "logging.googleapis.com/labels" field f.
+func asPayloadLabels(f zapcore.Field) (map[string]string, bool) {
+	if f.Key != keyLabels {
+		return nil, false
+	}
+	switch m := f.Interface.(type) {
+	case labelsField:
+		return m, f.Type == zapcore.ObjectMarshalerType
+	case stringMap:
+		return m, f.Type == zapcore.ObjectMarshalerType
+	case map[string]string:
+		return m, f.Type == zapcore.ReflectType
+	}
+
+	return nil, false
+}
+
+// promotedLabels returns the labels of f, if f is the field returned by WithLabels, or the
+// "logging.googleapis.com/labels" field promoted by WithPromotePayloadLabels.
+func (e *Encoder) promotedLabels(f zapcore.Field) (map[string]string, bool) {
+	m, ok := asPayloadLabels(f)
+	if !ok {
+		return nil, false
+	}
+	if _, always := f.Interface.(labelsField); !always && !e.opts.promoteLabels {
+		return nil, false
+	}
+
+	return m, true
+}
+
+// extractLabels removes the fields of the promoted labels, and returns their labels merged in
+// order. See promotedLabels.
+func (e *Encoder) extractLabels(fields []zapcore.Field) ([]zapcore.Field, map[string]string) {
+	var (
+		output []zapcore.Field
+		labels map[string]string
+	)
+	for i, f := range fields {
+		m, ok := e.promotedLabels(f)
+		if !ok {
+			if output != nil {
+				output = append(output, f)
+			}
+			continue
+		}
+		if output == nil {
+			output = make([]zapcore.Field, i, len(fields))
+			copy(output, fields[:i])
+		}
+		labels = mergeLabels(labels, m)
+	}
+	if output == nil {
+		return fields, nil
+	}
+
+	return output, labels
+}
+
+// entryLabels returns the labels of the entry delivered to Stackdriver, which has the labels of
+// WithLabels and the payload labels promoted by WithPromotePayloadLabels.
+func (e *Encoder) entryLabels(ent zapcore.Entry, payloadLabels map[string]string) map[string]string {
+	labels := mergeLabels(e.opts.labels, payloadLabels)
+	if e.opts.loggerNameLabel != "" && ent.LoggerName != "" {
+		if labels == nil {
+			labels = make(map[string]string, 1)
+		}
+		labels[e.opts.loggerNameLabel] = ent.LoggerName
+	}
+
+	return limitLabels(labels, e.opts.labelLimits)
+}
+
+// limitLabels truncates the keys and values of labels, and drops the excess labels in the
+// key order, to fit within limits together with the labelTruncated marker added if any label
+// was changed. The keys truncated to the same key keep the value of the first of them in the
+// key order. labels is left untouched; the returned map is a new one if any label is changed.
+func limitLabels(labels map[string]string, limits labelLimits) map[string]string {
+	if !exceedsLabelLimits(labels, limits) {
+		return labels
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	output := make(map[string]string, len(labels))
+	truncated := false
+	for _, k := range keys {
+		key, value := k, labels[k]
+		if limits.keyLength > 0 && len(key) > limits.keyLength {
+			key = cutString(key, limits.keyLength)
+			truncated = true
+		}
+		if limits.valueLength > 0 && len(value) > limits.valueLength {
+			value = cutString(value, limits.valueLength)
+			truncated = true
+		}
+		if _, ok := output[key]; ok {
+			truncated = true
+			continue
+		}
+		output[key] = value
+	}
+
+	if limits.count > 0 && len(output) > limits.count {
+		truncated = true
+	}
+	// leave room for the marker, also if the labels were only shortened.
+	if limits.count > 0 && truncated && len(output) > limits.count-1 {
+		keys = keys[:0]
+		for k := range output {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys[limits.count-1:] {
+			delete(output, k)
+		}
+	}
+
+	if truncated {
+		output[labelTruncated] = "true"
+	}
+
+	return output
+}
+
+// exceedsLabelLimits reports whether any of labels exceeds limits.
+func exceedsLabelLimits(labels map[string]string, limits labelLimits) bool {
+	if limits.count > 0 && len(labels) > limits.count {
+		return true
+	}
+	for k, v := range labels {
+		if limits.keyLength > 0 && len(k) > limits.keyLength {
+			return true
+		}
+		if limits.valueLength > 0 && len(v) > limits.valueLength {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cutString cuts s to at most n bytes without splitting a UTF-8 sequence.
+func cutString(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
+
+func buildInfoLabels(readBuildInfo func() (*debug.BuildInfo, bool)) map[string]string {
+	bi, ok := readBuildInfo()
+	if !ok || bi == nil {
+		return nil
+	}
+
+	labels := make(map[string]string)
+	if bi.GoVersion != "" {
+		labels[labelGoVersion] = bi.GoVersion
+	}
+	if bi.Main.Version != "" {
+		labels[labelModuleVersion] = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		if s.Key == labelVCSRevision && s.Value != "" {
+			labels[labelVCSRevision] = s.Value
+		}
+	}
+
+	return labels
+}
+
+// mergeLabels returns a new map which has the labels of dst overridden by src.
+func mergeLabels(dst, src map[string]string) map[string]string {
+	if len(dst) == 0 && len(src) == 0 {
+		return nil
+	}
+
+	labels := make(map[string]string, len(dst)+len(src))
+	for k, v := range dst {
+		labels[k] = v
+	}
+	for k, v := range src {
+		labels[k] = v
+	}
+
+	return labels
+}
@@ -0,0 +1,327 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/zchee/zap-encoder/internal/testutil"
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+func TestEncoderWithBuildInfoLabels(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+		stackdriver.WithBuildInfoLabels(),
+	)
+	for i := 0; i < 2; i++ {
+		buf, err := enc.EncodeEntry(zapcore.Entry{Message: "msg"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Free()
+	}
+
+	want := map[string]string{}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		want["go_version"] = bi.GoVersion
+		if bi.Main.Version != "" {
+			want["module_version"] = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" {
+				want["vcs.revision"] = s.Value
+			}
+		}
+	}
+	entries := lg.Entries()
+	for _, entry := range entries {
+		if diff := cmp.Diff(entry.Labels, want, cmpEmptyMap); diff != "" {
+			t.Errorf("labels: (-got, +want)\n%s", diff)
+		}
+	}
+	if len(entries[0].Labels) > 0 {
+		entries[0].Labels["go_version"] = "modified"
+		if entries[1].Labels["go_version"] == "modified" {
+			t.Error("the entries share the labels map")
+		}
+	}
+}
+
+func TestEncoderWithLoggerNameLabel(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel,
+		stackdriver.WithLoggerNameLabel("logger"),
+	)
+
+	logger.Named("payments").Info("msg")
+	logger.Info("msg")
+
+	entries := lg.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if diff := cmp.Diff(entries[0].Labels, map[string]string{"logger": "payments"}); diff != "" {
+		t.Errorf("named: (-got, +want)\n%s", diff)
+	}
+	if got, ok := entries[1].Labels["logger"]; ok {
+		t.Errorf("unnamed: got label %q, want none", got)
+	}
+}
+
+func TestEncoderWithHostnameLabel(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "resolved", want: "host-1"},
+		{name: "fallback", err: errors.New("no host name"), want: "unknown"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			restore := stackdriver.SetHostname(func() (string, error) {
+				calls++
+				if tt.err != nil {
+					return "", tt.err
+				}
+				return "host-1", nil
+			})
+			defer restore()
+
+			lg := testutil.NewFakeLogger()
+			logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel,
+				stackdriver.WithHostnameLabel("host", "unknown"),
+			)
+			for i := 0; i < 3; i++ {
+				logger.Info("msg")
+			}
+
+			for _, entry := range lg.Entries() {
+				if diff := cmp.Diff(entry.Labels, map[string]string{"host": tt.want}); diff != "" {
+					t.Errorf("(-got, +want)\n%s", diff)
+				}
+			}
+			if calls != 1 {
+				t.Errorf("got %d host name resolutions, want 1", calls)
+			}
+		})
+	}
+}
+
+func TestEncoderPayloadLabels(t *testing.T) {
+	tests := []struct {
+		name        string
+		promote     bool
+		field       zapcore.Field
+		with        bool
+		wantLabels  map[string]string
+		wantPayload interface{}
+	}{
+		{
+			name:        "pass through",
+			field:       stackdriver.WithPayloadLabels(map[string]string{"tenant": "acme"}),
+			wantLabels:  map[string]string{"logger": "payments"},
+			wantPayload: map[string]interface{}{"tenant": "acme"},
+		},
+		{
+			name:       "promoted",
+			promote:    true,
+			field:      stackdriver.WithPayloadLabels(map[string]string{"tenant": "acme", "logger": "override"}),
+			wantLabels: map[string]string{"logger": "payments", "tenant": "acme"},
+		},
+		{
+			name:       "promoted any",
+			promote:    true,
+			field:      zap.Any("logging.googleapis.com/labels", map[string]string{"tenant": "acme"}),
+			wantLabels: map[string]string{"logger": "payments", "tenant": "acme"},
+		},
+		{
+			name:       "promoted with",
+			promote:    true,
+			field:      zap.Any("logging.googleapis.com/labels", map[string]string{"tenant": "acme"}),
+			with:       true,
+			wantLabels: map[string]string{"logger": "payments", "tenant": "acme"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			lg := testutil.NewFakeLogger()
+			logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel,
+				stackdriver.WithLoggerNameLabel("logger"),
+				stackdriver.WithPromotePayloadLabels(tt.promote),
+			).Named("payments")
+			if tt.with {
+				logger.With(tt.field).Info("msg")
+			} else {
+				logger.Info("msg", tt.field)
+			}
+
+			entry := lg.Entries()[0]
+			if diff := cmp.Diff(entry.Labels, tt.wantLabels); diff != "" {
+				t.Errorf("labels: (-got, +want)\n%s", diff)
+			}
+			got, ok := decodePayload(t, entry)["logging.googleapis.com/labels"]
+			if tt.wantPayload == nil && ok {
+				t.Errorf("got the payload labels %v, want none", got)
+			}
+			if diff := cmp.Diff(got, tt.wantPayload); tt.wantPayload != nil && diff != "" {
+				t.Errorf("payload: (-got, +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEncoderWithLabels(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel).
+		With(stackdriver.WithLabels(map[string]string{"tenant": "acme", "region": "us"}))
+	logger.Info("msg", stackdriver.WithLabels(map[string]string{"region": "eu", "request": "r-1"}))
+	logger.Info("msg")
+
+	tests := []map[string]string{
+		{"tenant": "acme", "region": "eu", "request": "r-1"},
+		{"tenant": "acme", "region": "us"},
+	}
+	entries := lg.Entries()
+	for i, want := range tests {
+		if diff := cmp.Diff(entries[i].Labels, want); diff != "" {
+			t.Errorf("#%d: (-got, +want)\n%s", i, diff)
+		}
+		if _, ok := decodePayload(t, entries[i])["logging.googleapis.com/labels"]; ok {
+			t.Errorf("#%d: the labels are in the payload", i)
+		}
+	}
+}
+
+func TestEncoderWithLabelLimits(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel,
+		stackdriver.WithLabelLimits(3, 8, 4),
+		stackdriver.WithLoggerNameLabel("logger"),
+	)
+
+	logger.Named("ok").Info("msg")
+	logger.Named("payments").Info("msg")
+
+	limited := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel,
+		stackdriver.WithLabelLimits(2, 0, 0),
+		stackdriver.WithBuildInfoLabels(),
+		stackdriver.WithLoggerNameLabel("logger"),
+	)
+	limited.Named("payments").Info("msg")
+
+	full := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel,
+		stackdriver.WithLabelLimits(2, 0, 3),
+		stackdriver.WithLoggerNameLabel("logger"),
+	)
+	full.Named("payments").Info("msg", stackdriver.WithLabels(map[string]string{"env": "prod"}))
+
+	// the keys sharing the prefix are truncated to the same key, which keeps the first value.
+	shared := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel,
+		stackdriver.WithLabelLimits(0, 4, 0),
+	)
+	shared.Info("msg", stackdriver.WithLabels(map[string]string{"envABB": "b", "envAAA": "a", "env": "c"}))
+
+	entries := lg.Entries()
+	if diff := cmp.Diff(entries[4].Labels, map[string]string{"env": "c", "envA": "a", "labels_truncated": "true"}); diff != "" {
+		t.Errorf("key collision: (-got, +want)\n%s", diff)
+	}
+	if diff := cmp.Diff(entries[0].Labels, map[string]string{"logger": "ok"}); diff != "" {
+		t.Errorf("within limits: (-got, +want)\n%s", diff)
+	}
+	if diff := cmp.Diff(entries[1].Labels, map[string]string{"logger": "paym", "labels_truncated": "true"}); diff != "" {
+		t.Errorf("value length: (-got, +want)\n%s", diff)
+	}
+
+	// the marker of the shortened value takes the room of the label last in the key order.
+	if diff := cmp.Diff(entries[3].Labels, map[string]string{"env": "pro", "labels_truncated": "true"}); diff != "" {
+		t.Errorf("count with value length: (-got, +want)\n%s", diff)
+	}
+
+	labels := entries[2].Labels
+	if len(labels) > 2 {
+		t.Errorf("got %d labels, want at most 2: %v", len(labels), labels)
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok || bi.GoVersion == "" || bi.Main.Version == "" {
+		t.Skip("the build info labels are unavailable")
+	}
+	// go_version survives in the key order, logger and module_version are dropped.
+	want := map[string]string{
+		"go_version":       bi.GoVersion,
+		"labels_truncated": "true",
+	}
+	if diff := cmp.Diff(labels, want); diff != "" {
+		t.Errorf("count: (-got, +want)\n%s", diff)
+	}
+}
+
+func TestBuildInfoLabels(t *testing.T) {
+	tests := []struct {
+		name string
+		bi   *debug.BuildInfo
+		want map[string]string
+	}{
+		{
+			name: "unavailable",
+			bi:   nil,
+			want: nil,
+		},
+		{
+			name: "without vcs",
+			bi: &debug.BuildInfo{
+				GoVersion: "go1.20",
+				Main:      debug.Module{Path: "example.com/app", Version: "(devel)"},
+			},
+			want: map[string]string{
+				"go_version":     "go1.20",
+				"module_version": "(devel)",
+			},
+		},
+		{
+			name: "with vcs",
+			bi: &debug.BuildInfo{
+				GoVersion: "go1.20",
+				Main:      debug.Module{Path: "example.com/app", Version: "v1.2.3"},
+				Settings: []debug.BuildSetting{
+					{Key: "vcs", Value: "git"},
+					{Key: "vcs.revision", Value: "58adff1"},
+				},
+			},
+			want: map[string]string{
+				"go_version":     "go1.20",
+				"module_version": "v1.2.3",
+				"vcs.revision":   "58adff1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got := stackdriver.BuildInfoLabels(func() (*debug.BuildInfo, bool) {
+				return tt.bi, tt.bi != nil
+			})
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("(-got, +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+// cmpEmptyMap treats the nil and empty maps as equal.
+var cmpEmptyMap = cmp.FilterValues(func(x, y map[string]string) bool {
+	return len(x) == 0 && len(y) == 0
+}, cmp.Comparer(func(_, _ map[string]string) bool { return true }))
@@ -0,0 +1,117 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LoggerFactory returns a new Logger which delivers entries to the projectID project.
+type LoggerFactory func(projectID string) (Logger, error)
+
+// NewLoggerFactory returns the LoggerFactory which creates the stackdriver logging
+// client for the logID log of each project, see NewStackdriverClient.
+func NewLoggerFactory(ctx context.Context, logID string, opts ...ClientOption) LoggerFactory {
+	return func(projectID string) (Logger, error) {
+		lg, err := NewStackdriverClient(ctx, projectID, logID, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return lg, nil
+	}
+}
+
+// loggerRetryInterval is the interval the loggerCache waits before creating the logger again
+// once the creation failed, so the entries for the key don't retry it one by one.
+const loggerRetryInterval = time.Minute
+
+// loggerCache caches the loggers created by newLogger by key.
+type loggerCache struct {
+	newLogger LoggerFactory
+
+	mu       sync.Mutex
+	loggers  map[string]Logger
+	failures map[string]loggerFailure
+	calls    map[string]*loggerCall
+}
+
+// loggerFailure is the cached error of the logger creation, until the retry.
+type loggerFailure struct {
+	err   error
+	retry time.Time
+}
+
+// loggerCall is the logger creation in flight, which the other callers for the key wait for.
+type loggerCall struct {
+	done chan struct{}
+	lg   Logger
+	err  error
+}
+
+func newLoggerCache(newLogger LoggerFactory) *loggerCache {
+	return &loggerCache{
+		newLogger: newLogger,
+		loggers:   make(map[string]Logger),
+		failures:  make(map[string]loggerFailure),
+		calls:     make(map[string]*loggerCall),
+	}
+}
+
+// get returns the cached logger for key, creating it on first use. The logger is created
+// without holding the lock, so the callers for the other keys aren't blocked by the creation.
+// The creation error is cached for loggerRetryInterval.
+func (c *loggerCache) get(key string) (Logger, error) {
+	c.mu.Lock()
+	if lg, ok := c.loggers[key]; ok {
+		c.mu.Unlock()
+		return lg, nil
+	}
+	if f, ok := c.failures[key]; ok && timeNow().Before(f.retry) {
+		c.mu.Unlock()
+		return nil, f.err
+	}
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.lg, call.err
+	}
+	call := &loggerCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	call.lg, call.err = c.newLogger(key)
+
+	c.mu.Lock()
+	delete(c.calls, key)
+	if call.err != nil {
+		c.failures[key] = loggerFailure{err: call.err, retry: timeNow().Add(loggerRetryInterval)}
+	} else {
+		delete(c.failures, key)
+		c.loggers[key] = call.lg
+	}
+	c.mu.Unlock()
+	close(call.done)
+
+	return call.lg, call.err
+}
+
+// all returns the cached loggers. It returns nil if c is nil.
+func (c *loggerCache) all() []Logger {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	loggers := make([]Logger, 0, len(c.loggers))
+	for _, lg := range c.loggers {
+		loggers = append(loggers, lg)
+	}
+
+	return loggers
+}
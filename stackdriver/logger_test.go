@@ -0,0 +1,337 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sdlogging "cloud.google.com/go/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/zchee/zap-encoder/internal/testutil"
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+func TestEncoderWithProjectIDResolver(t *testing.T) {
+	loggers := make(map[string]*testutil.FakeLogger)
+	var brokenCalls int
+	newLogger := func(projectID string) (stackdriver.Logger, error) {
+		if projectID == "broken-project" {
+			brokenCalls++
+			return nil, errors.New("permission denied")
+		}
+		if _, ok := loggers[projectID]; ok {
+			t.Errorf("logger for %s was created twice", projectID)
+		}
+		lg := testutil.NewFakeLogger()
+		loggers[projectID] = lg
+		return lg, nil
+	}
+	resolve := func(ent zapcore.Entry, fields []zapcore.Field) string {
+		for _, f := range fields {
+			if f.Key == "audit" {
+				return "audit-project"
+			}
+		}
+		if ent.LoggerName == "broken" {
+			return "broken-project"
+		}
+		return ""
+	}
+
+	lg := testutil.NewFakeLogger()
+	core := zapcore.NewCore(
+		stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+			stackdriver.WithProjectIDResolver(resolve, newLogger),
+		),
+		zapcore.AddSync(ioutil.Discard),
+		zapcore.DebugLevel,
+	)
+	logger := zap.New(core)
+
+	logger.Info("app")
+	logger.Info("audit 1", zap.Bool("audit", true))
+	logger.With(zap.String("user", "bob")).Info("audit 2", zap.Bool("audit", true))
+
+	if got := len(lg.Entries()); got != 1 {
+		t.Errorf("default project: got %d entries, want 1", got)
+	}
+	if got := len(loggers["audit-project"].Entries()); got != 2 {
+		t.Errorf("audit-project: got %d entries, want 2", got)
+	}
+
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	restore := stackdriver.SetTimeNow(func() time.Time { return now })
+	defer restore()
+
+	var (
+		out     bytes.Buffer
+		handled []error
+	)
+	broken := zap.New(zapcore.NewCore(
+		stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+			stackdriver.WithProjectIDResolver(resolve, newLogger),
+			stackdriver.WithErrorHandler(func(err error) { handled = append(handled, err) }),
+		),
+		zapcore.AddSync(&out),
+		zapcore.DebugLevel,
+	)).Named("broken")
+	broken.Info("fallback 1")
+	broken.Info("fallback 2")
+	now = now.Add(2 * time.Minute)
+	broken.Info("fallback 3")
+
+	if got := len(lg.Entries()); got != 4 {
+		t.Errorf("default project: got %d entries, want 4", got)
+	}
+	if got := strings.Count(out.String(), "fallback"); got != 3 {
+		t.Errorf("got %d entries written by the core, want 3: %s", got, out.String())
+	}
+	if len(handled) != 3 {
+		t.Errorf("got %d handled errors, want 3: %v", len(handled), handled)
+	}
+	if brokenCalls != 2 {
+		t.Errorf("broken-project: got %d logger creations, want 2 (cached, then retried)", brokenCalls)
+	}
+}
+
+func TestEncoderWithProjectIDResolverConcurrentCreation(t *testing.T) {
+	release := make(chan struct{})
+	var (
+		mu    sync.Mutex
+		calls = make(map[string]int)
+	)
+	newLogger := func(projectID string) (stackdriver.Logger, error) {
+		mu.Lock()
+		calls[projectID]++
+		mu.Unlock()
+		if projectID == "slow-project" {
+			<-release
+		}
+		return testutil.NewFakeLogger(), nil
+	}
+	resolve := func(ent zapcore.Entry, _ []zapcore.Field) string { return ent.Message }
+	logger := stackdriver.NewLogger(context.Background(), testutil.NewFakeLogger(), zapcore.InfoLevel,
+		stackdriver.WithProjectIDResolver(resolve, newLogger))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logger.Info("slow-project")
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		logger.Info("fast-project")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the entry for fast-project is blocked by the logger creation for slow-project")
+	}
+	close(release)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls["slow-project"] != 1 {
+		t.Errorf("slow-project: got %d logger creations, want 1", calls["slow-project"])
+	}
+}
+
+func TestEncoderWithConsoleFallback(t *testing.T) {
+	assertLines := func(t *testing.T, buf *bytes.Buffer, msgs ...string) {
+		t.Helper()
+
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		if len(lines) != len(msgs) {
+			t.Fatalf("got %d lines, want %d: %q", len(lines), len(msgs), buf.String())
+		}
+		for i, msg := range msgs {
+			var got map[string]interface{}
+			if err := json.Unmarshal([]byte(lines[i]), &got); err != nil {
+				t.Fatalf("line %d is not valid json: %v", i, err)
+			}
+			if got["message"] != msg {
+				t.Errorf("line %d: got message %v, want %s", i, got["message"], msg)
+			}
+		}
+	}
+
+	var nilClient *sdlogging.Logger
+	for name, lg := range map[string]stackdriver.Logger{
+		"no logger":  nil,
+		"nil client": nilClient,
+	} {
+		lg := lg
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel, stackdriver.WithConsoleFallback(&buf))
+			logger.Info("first", zap.Int("n", 1))
+			logger.Warn("second", zap.Int("n", 2))
+			if err := logger.Sync(); err != nil {
+				t.Fatal(err)
+			}
+
+			assertLines(t, &buf, "first", "second")
+		})
+	}
+
+	t.Run("persistent delivery failure", func(t *testing.T) {
+		var buf bytes.Buffer
+		lg := testutil.NewFakeLogger()
+		logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel, stackdriver.WithConsoleFallback(&buf))
+
+		logger.Info("delivered")
+		lg.FlushErr = errors.New("unavailable")
+		for i := 0; i < 3; i++ {
+			logger.Info("lost")
+			_ = logger.Sync()
+		}
+		logger.Info("first")
+		logger.Info("second")
+
+		if got := len(lg.Entries()); got != 4 {
+			t.Errorf("got %d delivered entries, want 4", got)
+		}
+		assertLines(t, &buf, "first", "second")
+	})
+
+	t.Run("client errors without sync", func(t *testing.T) {
+		var buf bytes.Buffer
+		lg := testutil.NewFakeLogger()
+		enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(), stackdriver.WithConsoleFallback(&buf)).(*stackdriver.Encoder)
+		logger := zap.New(zapcore.NewCore(enc, zapcore.AddSync(ioutil.Discard), zapcore.InfoLevel))
+
+		logger.Info("delivered")
+		for i := 0; i < 3; i++ {
+			logger.Info("lost")
+			enc.OnError(errors.New("bundle upload failed"))
+		}
+		logger.Info("first")
+		logger.Info("second")
+
+		if got := len(lg.Entries()); got != 4 {
+			t.Errorf("got %d delivered entries, want 4", got)
+		}
+		if got := lg.Flushes(); got != 0 {
+			t.Errorf("got %d flushes, want 0", got)
+		}
+		assertLines(t, &buf, "first", "second")
+	})
+
+	t.Run("slow flush", func(t *testing.T) {
+		var buf bytes.Buffer
+		lg := &asyncLogger{FakeLogger: testutil.NewFakeLogger(), block: make(chan struct{})}
+		logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel, stackdriver.WithConsoleFallback(&buf))
+
+		synced := make(chan struct{})
+		go func() {
+			_ = logger.Sync()
+			close(synced)
+		}()
+		logged := make(chan struct{})
+		go func() {
+			logger.Info("during the flush")
+			close(logged)
+		}()
+		select {
+		case <-logged:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the entry is blocked by the pending flush")
+		}
+		close(lg.block)
+		<-synced
+
+		if got := len(lg.Entries()); got != 1 {
+			t.Errorf("got %d delivered entries, want 1", got)
+		}
+	})
+}
+
+// asyncLogger delivers the entries in the background after delay, like the bundler of the
+// logging client, and Flush waits for the delivery.
+type asyncLogger struct {
+	*testutil.FakeLogger
+	delay   time.Duration
+	block   chan struct{} // Flush blocks until it's closed, if not nil
+	pending sync.WaitGroup
+}
+
+func (l *asyncLogger) Log(e sdlogging.Entry) {
+	l.pending.Add(1)
+	go func() {
+		defer l.pending.Done()
+		time.Sleep(l.delay)
+		l.FakeLogger.Log(e)
+	}()
+}
+
+func (l *asyncLogger) Flush() error {
+	if l.block != nil {
+		<-l.block
+	}
+	l.pending.Wait()
+	return l.FakeLogger.Flush()
+}
+
+func TestWriteSyncerSync(t *testing.T) {
+	t.Run("drain", func(t *testing.T) {
+		lg := &asyncLogger{FakeLogger: testutil.NewFakeLogger(), delay: 10 * time.Millisecond}
+		logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel, stackdriver.WithSyncTimeout(time.Minute))
+		for i := 0; i < 10; i++ {
+			logger.Info("msg", zap.Int("i", i))
+		}
+
+		if err := logger.Sync(); err != nil {
+			t.Fatal(err)
+		}
+		if got := len(lg.Entries()); got != 10 {
+			t.Errorf("got %d entries delivered before Sync returned, want 10", got)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		lg := &asyncLogger{FakeLogger: testutil.NewFakeLogger(), block: make(chan struct{})}
+		defer close(lg.block)
+		logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel, stackdriver.WithSyncTimeout(10*time.Millisecond))
+		logger.Info("msg")
+
+		if err := logger.Sync(); err != stackdriver.ErrSyncTimeout {
+			t.Errorf("got error %v, want ErrSyncTimeout", err)
+		}
+	})
+
+	t.Run("project loggers", func(t *testing.T) {
+		audit := testutil.NewFakeLogger()
+		audit.FlushErr = errors.New("audit flush failed")
+		newLogger := func(string) (stackdriver.Logger, error) { return audit, nil }
+		resolve := func(zapcore.Entry, []zapcore.Field) string { return "audit-project" }
+
+		logger := stackdriver.NewLogger(context.Background(), testutil.NewFakeLogger(), zapcore.InfoLevel,
+			stackdriver.WithProjectIDResolver(resolve, newLogger))
+		logger.Info("msg")
+
+		if err := logger.Sync(); err == nil || !strings.Contains(err.Error(), "audit flush failed") {
+			t.Errorf("got error %v, want the flush error of the project logger", err)
+		}
+		if got := audit.Flushes(); got != 1 {
+			t.Errorf("got %d flushes of the project logger, want 1", got)
+		}
+	})
+}
@@ -0,0 +1,217 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// keyMarshalError is the key of the placeholder which replaces the object failed to marshal.
+const keyMarshalError = "_error"
+
+// safeObjectMarshaler marshals obj, or the {"_error":"..."} placeholder if the MarshalLogObject
+// of obj fails, rather than the partial object and the "<key>Error" field added by zap. The
+// objects nested in obj are marshaled by safeObjectMarshaler in turn, so only the failed one is
+// replaced.
+type safeObjectMarshaler struct {
+	obj zapcore.ObjectMarshaler
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (m safeObjectMarshaler) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	rec := acquireFieldRecorder()
+	defer releaseFieldRecorder(rec)
+
+	if err := m.obj.MarshalLogObject(rec); err != nil {
+		enc.AddString(keyMarshalError, err.Error())
+		return nil
+	}
+	for _, f := range rec.fields {
+		f.AddTo(enc)
+	}
+
+	return nil
+}
+
+// safeObjectFields returns the fields whose objects are marshaled by safeObjectMarshaler. The
+// fields slice is copied on the first change.
+func safeObjectFields(fields []zapcore.Field) []zapcore.Field {
+	var output []zapcore.Field
+	for i, f := range fields {
+		if f.Type != zapcore.ObjectMarshalerType {
+			continue
+		}
+		if output == nil {
+			output = make([]zapcore.Field, len(fields))
+			copy(output, fields)
+		}
+		output[i] = zap.Object(f.Key, safeObjectMarshaler{obj: f.Interface.(zapcore.ObjectMarshaler)})
+	}
+	if output == nil {
+		return fields
+	}
+
+	return output
+}
+
+// fieldRecorder is the zapcore.ObjectEncoder which records the fields added by MarshalLogObject,
+// to add them to the actual encoder only once the object is marshaled without error.
+type fieldRecorder struct {
+	fields []zapcore.Field
+}
+
+// fieldRecorderPool pools the fieldRecorders, which are only referenced until the object is
+// marshaled.
+var fieldRecorderPool = sync.Pool{
+	New: func() interface{} {
+		return new(fieldRecorder)
+	},
+}
+
+// acquireFieldRecorder returns an empty fieldRecorder from the pool.
+func acquireFieldRecorder() *fieldRecorder {
+	return fieldRecorderPool.Get().(*fieldRecorder)
+}
+
+// releaseFieldRecorder returns rec to the pool.
+func releaseFieldRecorder(rec *fieldRecorder) {
+	for i := range rec.fields {
+		rec.fields[i] = zapcore.Field{}
+	}
+	rec.fields = rec.fields[:0]
+	fieldRecorderPool.Put(rec)
+}
+
+// AddArray implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	rec.fields = append(rec.fields, zap.Array(key, arr))
+	return nil
+}
+
+// AddObject implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	rec.fields = append(rec.fields, zap.Object(key, safeObjectMarshaler{obj: obj}))
+	return nil
+}
+
+// AddBinary implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddBinary(key string, value []byte) {
+	rec.fields = append(rec.fields, zap.Binary(key, value))
+}
+
+// AddByteString implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddByteString(key string, value []byte) {
+	rec.fields = append(rec.fields, zap.ByteString(key, value))
+}
+
+// AddBool implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddBool(key string, value bool) {
+	rec.fields = append(rec.fields, zap.Bool(key, value))
+}
+
+// AddComplex128 implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddComplex128(key string, value complex128) {
+	rec.fields = append(rec.fields, zap.Complex128(key, value))
+}
+
+// AddComplex64 implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddComplex64(key string, value complex64) {
+	rec.fields = append(rec.fields, zap.Complex64(key, value))
+}
+
+// AddDuration implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddDuration(key string, value time.Duration) {
+	rec.fields = append(rec.fields, zap.Duration(key, value))
+}
+
+// AddFloat64 implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddFloat64(key string, value float64) {
+	rec.fields = append(rec.fields, zap.Float64(key, value))
+}
+
+// AddFloat32 implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddFloat32(key string, value float32) {
+	rec.fields = append(rec.fields, zap.Float32(key, value))
+}
+
+// AddInt implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddInt(key string, value int) {
+	rec.fields = append(rec.fields, zap.Int(key, value))
+}
+
+// AddInt64 implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddInt64(key string, value int64) {
+	rec.fields = append(rec.fields, zap.Int64(key, value))
+}
+
+// AddInt32 implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddInt32(key string, value int32) {
+	rec.fields = append(rec.fields, zap.Int32(key, value))
+}
+
+// AddInt16 implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddInt16(key string, value int16) {
+	rec.fields = append(rec.fields, zap.Int16(key, value))
+}
+
+// AddInt8 implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddInt8(key string, value int8) {
+	rec.fields = append(rec.fields, zap.Int8(key, value))
+}
+
+// AddString implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddString(key, value string) {
+	rec.fields = append(rec.fields, zap.String(key, value))
+}
+
+// AddTime implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddTime(key string, value time.Time) {
+	rec.fields = append(rec.fields, zap.Time(key, value))
+}
+
+// AddUint implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddUint(key string, value uint) {
+	rec.fields = append(rec.fields, zap.Uint(key, value))
+}
+
+// AddUint64 implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddUint64(key string, value uint64) {
+	rec.fields = append(rec.fields, zap.Uint64(key, value))
+}
+
+// AddUint32 implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddUint32(key string, value uint32) {
+	rec.fields = append(rec.fields, zap.Uint32(key, value))
+}
+
+// AddUint16 implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddUint16(key string, value uint16) {
+	rec.fields = append(rec.fields, zap.Uint16(key, value))
+}
+
+// AddUint8 implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddUint8(key string, value uint8) {
+	rec.fields = append(rec.fields, zap.Uint8(key, value))
+}
+
+// AddUintptr implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddUintptr(key string, value uintptr) {
+	rec.fields = append(rec.fields, zap.Uintptr(key, value))
+}
+
+// AddReflected implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) AddReflected(key string, value interface{}) error {
+	rec.fields = append(rec.fields, zap.Reflect(key, value))
+	return nil
+}
+
+// OpenNamespace implements zapcore.ObjectEncoder.
+func (rec *fieldRecorder) OpenNamespace(key string) {
+	rec.fields = append(rec.fields, zap.Namespace(key))
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/zchee/zap-encoder/internal/testutil"
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+type order struct {
+	id       string
+	customer *stackdriver.ServiceContext
+}
+
+func (o order) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("id", o.id)
+	return enc.AddObject("customer", o.customer)
+}
+
+func TestEncoderMarshalError(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel)
+	logger.With(zap.Object("withService", &stackdriver.ServiceContext{})).Info("msg",
+		zap.Object("service", &stackdriver.ServiceContext{Version: "v1"}),
+		zap.Object("order", order{id: "o-1", customer: &stackdriver.ServiceContext{}}),
+		zap.String("so", "passes"),
+	)
+
+	entries := lg.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	want := map[string]interface{}{
+		"severity":    "INFO",
+		"message":     "msg",
+		"withService": map[string]interface{}{"_error": "service name is mandatory"},
+		"service":     map[string]interface{}{"_error": "service name is mandatory"},
+		"order": map[string]interface{}{
+			"id":       "o-1",
+			"customer": map[string]interface{}{"_error": "service name is mandatory"},
+		},
+		"so": "passes",
+	}
+	payload := decodePayload(t, entries[0])
+	delete(payload, "eventTime")
+	delete(payload, "caller")
+	if diff := cmp.Diff(payload, want); diff != "" {
+		t.Errorf("(-got, +want)\n%s", diff)
+	}
+}
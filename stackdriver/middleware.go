@@ -0,0 +1,160 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"net"
+	"net/http"
+
+	sdlogging "cloud.google.com/go/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// responseRecorder records the status and the size of the response written through it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	size        int64
+	wroteHeader bool
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *responseRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter.
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+
+	return n, err
+}
+
+// wrapResponseWriter returns rec as the http.ResponseWriter which also implements the
+// http.Flusher, http.Hijacker and http.Pusher implemented by the writer rec wraps, so the
+// handlers which stream the response or upgrade the connection keep working behind the
+// LoggingMiddleware.
+func wrapResponseWriter(rec *responseRecorder) http.ResponseWriter {
+	f, isFlusher := rec.ResponseWriter.(http.Flusher)
+	h, isHijacker := rec.ResponseWriter.(http.Hijacker)
+	p, isPusher := rec.ResponseWriter.(http.Pusher)
+
+	switch {
+	case isFlusher && isHijacker && isPusher:
+		return struct {
+			*responseRecorder
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{rec, f, h, p}
+	case isFlusher && isHijacker:
+		return struct {
+			*responseRecorder
+			http.Flusher
+			http.Hijacker
+		}{rec, f, h}
+	case isFlusher && isPusher:
+		return struct {
+			*responseRecorder
+			http.Flusher
+			http.Pusher
+		}{rec, f, p}
+	case isHijacker && isPusher:
+		return struct {
+			*responseRecorder
+			http.Hijacker
+			http.Pusher
+		}{rec, h, p}
+	case isFlusher:
+		return struct {
+			*responseRecorder
+			http.Flusher
+		}{rec, f}
+	case isHijacker:
+		return struct {
+			*responseRecorder
+			http.Hijacker
+		}{rec, h}
+	case isPusher:
+		return struct {
+			*responseRecorder
+			http.Pusher
+		}{rec, p}
+	}
+
+	return rec
+}
+
+// LoggingMiddleware returns the middleware which logs one entry per request to logger, with the
+// request method, status, latency and sizes set to the HTTP request of the entry delivered to
+// Stackdriver. See WithNativeHTTPRequest.
+//
+// The trace of the entry is read from the W3C traceparent header, or the X-Cloud-Trace-Context
+// header, of the request. The trace resource names are built with projectID. The entries of the
+// server errors are logged at ErrorLevel, and the others at InfoLevel.
+func LoggingMiddleware(logger *zap.Logger, projectID string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := timeNow()
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(wrapResponseWriter(rec), r)
+
+			lv := zapcore.InfoLevel
+			if rec.status >= http.StatusInternalServerError {
+				lv = zapcore.ErrorLevel
+			}
+			ce := logger.Check(lv, r.Method+" "+r.URL.Path)
+			if ce == nil {
+				return
+			}
+
+			req := &sdlogging.HTTPRequest{
+				Request:      r,
+				Status:       rec.status,
+				ResponseSize: rec.size,
+				Latency:      timeNow().Sub(start),
+				RemoteIP:     remoteIP(r.RemoteAddr),
+			}
+			if r.ContentLength > 0 {
+				req.RequestSize = r.ContentLength
+			}
+			fields := []zapcore.Field{WithNativeHTTPRequest(req)}
+			if tc, ok := requestTraceContext(r, projectID); ok {
+				fields = append(fields, zap.Object(keyTraceContext, tc))
+			}
+			ce.Write(fields...)
+		})
+	}
+}
+
+// requestTraceContext returns the trace context of the headers of r.
+func requestTraceContext(r *http.Request, projectID string) (*traceContext, bool) {
+	if h := r.Header.Get("traceparent"); h != "" {
+		if tc, ok := parseTraceparent(h, projectID); ok {
+			return tc, true
+		}
+	}
+	if h := r.Header.Get("X-Cloud-Trace-Context"); h != "" {
+		return parseCloudTraceContext(h, projectID)
+	}
+
+	return nil, false
+}
+
+// remoteIP returns the IP address of the remote address addr, which may have a port.
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return addr
+}
@@ -0,0 +1,166 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/zchee/zap-encoder/internal/testutil"
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+func TestLoggingMiddleware(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	tests := []struct {
+		name        string
+		header      string
+		value       string
+		wantSpanID  string
+		wantSampled bool
+	}{
+		{name: "traceparent", header: "traceparent", value: "00-" + traceID + "-00f067aa0ba902b7-01", wantSpanID: "00f067aa0ba902b7", wantSampled: true},
+		{name: "cloud trace context", header: "X-Cloud-Trace-Context", value: traceID + "/74;o=1", wantSpanID: "000000000000004a", wantSampled: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Date(2018, 6, 19, 16, 33, 42, 0, time.UTC)
+			now := start
+			restore := stackdriver.SetTimeNow(func() time.Time { return now })
+			defer restore()
+
+			lg := testutil.NewFakeLogger()
+			logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel)
+			handler := stackdriver.LoggingMiddleware(logger, "my-project")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(ioutil.Discard, r.Body)
+				now = start.Add(1500 * time.Millisecond)
+				w.WriteHeader(http.StatusCreated)
+				io.WriteString(w, "hello")
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "http://example.com/users?id=1", strings.NewReader("body"))
+			req.Header.Set(tt.header, tt.value)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusCreated || rec.Body.String() != "hello" {
+				t.Fatalf("got response %d %q, want %d %q", rec.Code, rec.Body.String(), http.StatusCreated, "hello")
+			}
+
+			entries := lg.Entries()
+			if len(entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(entries))
+			}
+			entry := entries[0]
+			hr := entry.HTTPRequest
+			if hr == nil {
+				t.Fatal("got no HTTP request on the entry")
+			}
+			if hr.Request != req || hr.Status != http.StatusCreated || hr.RequestSize != 4 || hr.ResponseSize != 5 ||
+				hr.Latency != 1500*time.Millisecond || hr.RemoteIP != "192.0.2.1" {
+				t.Errorf("got HTTP request %+v", hr)
+			}
+			if want := "projects/my-project/traces/" + traceID; entry.Trace != want {
+				t.Errorf("got trace %q, want %q", entry.Trace, want)
+			}
+
+			payload := decodePayload(t, entry)
+			if got, want := payload["message"], "POST /users"; got != want {
+				t.Errorf("got message %v, want %v", got, want)
+			}
+			if got := payload["logging.googleapis.com/spanId"]; got != tt.wantSpanID {
+				t.Errorf("got spanId %v, want %v", got, tt.wantSpanID)
+			}
+			if got := payload["logging.googleapis.com/trace_sampled"]; got != tt.wantSampled {
+				t.Errorf("got trace_sampled %v, want %v", got, tt.wantSampled)
+			}
+			if _, ok := payload["logging.googleapis.com/httpRequest"]; ok {
+				t.Error("got the HTTP request in the payload, want it on the entry only")
+			}
+		})
+	}
+}
+
+type plainWriter struct{ http.ResponseWriter }
+
+type hijackWriter struct{ *httptest.ResponseRecorder }
+
+func (hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) { return nil, nil, errHijacked }
+
+type pushWriter struct{ *httptest.ResponseRecorder }
+
+func (pushWriter) Push(string, *http.PushOptions) error { return nil }
+
+type fullWriter struct{ *httptest.ResponseRecorder }
+
+func (fullWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) { return nil, nil, errHijacked }
+
+func (fullWriter) Push(string, *http.PushOptions) error { return nil }
+
+var errHijacked = errors.New("hijacked")
+
+func TestLoggingMiddlewareWriterInterfaces(t *testing.T) {
+	tests := []struct {
+		name                      string
+		w                         http.ResponseWriter
+		flusher, hijacker, pusher bool
+	}{
+		{name: "plain", w: plainWriter{httptest.NewRecorder()}},
+		{name: "flusher", w: httptest.NewRecorder(), flusher: true},
+		{name: "hijacker", w: hijackWriter{httptest.NewRecorder()}, flusher: true, hijacker: true},
+		{name: "pusher", w: pushWriter{httptest.NewRecorder()}, flusher: true, pusher: true},
+		{name: "all", w: fullWriter{httptest.NewRecorder()}, flusher: true, hijacker: true, pusher: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			lg := testutil.NewFakeLogger()
+			logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel)
+			handler := stackdriver.LoggingMiddleware(logger, "my-project")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				f, ok := w.(http.Flusher)
+				if ok != tt.flusher {
+					t.Errorf("got http.Flusher %t, want %t", ok, tt.flusher)
+				}
+				h, ok := w.(http.Hijacker)
+				if ok != tt.hijacker {
+					t.Errorf("got http.Hijacker %t, want %t", ok, tt.hijacker)
+				}
+				if _, ok := w.(http.Pusher); ok != tt.pusher {
+					t.Errorf("got http.Pusher %t, want %t", ok, tt.pusher)
+				}
+				w.WriteHeader(http.StatusAccepted)
+				if f != nil {
+					f.Flush()
+				}
+				if h != nil {
+					if _, _, err := h.Hijack(); err != errHijacked {
+						t.Errorf("got Hijack error %v, want the error of the underlying writer", err)
+					}
+				}
+			}))
+			handler.ServeHTTP(tt.w, httptest.NewRequest(http.MethodGet, "http://example.com/stream", nil))
+
+			if rec, ok := tt.w.(*httptest.ResponseRecorder); ok && !rec.Flushed {
+				t.Error("the Flush isn't passed through to the underlying writer")
+			}
+			if got := lg.Entries()[0].HTTPRequest.Status; got != http.StatusAccepted {
+				t.Errorf("got status %d, want %d", got, http.StatusAccepted)
+			}
+		})
+	}
+}
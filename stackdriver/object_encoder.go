@@ -0,0 +1,397 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// The Encoder implements the zapcore.ObjectEncoder methods, which add the fields of the
+// zap.Logger.With, to transform the field keys by WithFieldKeyTransformer, to drop the fields
+// not in WithFieldAllowlist and to hold the fields for WithDedupeFields. Otherwise the fields
+// are passed through to the JSON encoder.
+
+// deferField holds the field added by the zap.Logger.With if the fields are deduplicated, and
+// reports whether it's held.
+func (e *Encoder) deferField(f zapcore.Field) bool {
+	if e.opts.dedupe == 0 {
+		return false
+	}
+	e.fields = append(e.fields, f)
+
+	return true
+}
+
+// AddArray implements zapcore.ObjectEncoder.
+func (e *Encoder) AddArray(key string, marshaler zapcore.ArrayMarshaler) error {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return nil
+	}
+	if e.deferField(zap.Array(key, marshaler)) {
+		return nil
+	}
+	if e.opts.maxObjectDepth > 0 {
+		marshaler = depthArrayMarshaler{arr: marshaler, depth: 1, max: e.opts.maxObjectDepth}
+	}
+	return e.Encoder.AddArray(key, marshaler)
+}
+
+// AddObject implements zapcore.ObjectEncoder.
+//
+// The field returned by WithTraceFromTraceparent is added as the top-level trace fields, the
+// resource of the field returned by WithMonitoredResource and the request of the field returned
+// by WithNativeHTTPRequest and the source location are delivered with the entries, the operation
+// is merged with the one of each entry, and the fields of the inline objects, e.g. the AuditLog,
+// are added to the entry itself. The LogContext of the context of the field returned by
+// WithRequestContext is applied to the entries, see WithContextExtraction. The object failed to
+// marshal is replaced with the {"_error":"..."} placeholder.
+func (e *Encoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	if tc, ok := obj.(*traceContext); ok && key == keyTraceContext && tc != nil {
+		e.trace = tc.Trace
+		if tc.InsertID != "" {
+			e.insertID = tc.InsertID
+		}
+		if e.deferField(zap.Object(key, obj)) {
+			return nil
+		}
+		return tc.MarshalLogObject(e.Encoder)
+	}
+	if ctx, ok := asRequestContext(zap.Object(key, obj)); ok {
+		if lc := logContextFrom(ctx, e.opts.logContextKey); lc != nil {
+			e.ctx = lc
+		}
+		return nil
+	}
+	if r, ok := obj.(monitoredResource); ok && key == keyMonitoredResource {
+		e.resource = r.MonitoredResource
+		return nil
+	}
+	if req, ok := obj.(nativeHTTPRequest); ok && key == keyNativeHTTPRequest {
+		e.httpRequest = req.HTTPRequest
+		return nil
+	}
+	if op, ok := asOperation(zap.Object(key, obj)); ok {
+		e.operation = e.operation.merge(op)
+		return nil
+	}
+	if sl, ok := asSourceLocation(zap.Object(key, obj)); ok {
+		e.sourceLocation = sl.Clone()
+		return nil
+	}
+	if m, ok := e.promotedLabels(zap.Object(key, obj)); ok {
+		e.labels = mergeLabels(e.labels, m)
+		return nil
+	}
+	if m, ok := obj.(inlineMarshaler); ok {
+		if e.deferField(zap.Object(key, obj)) {
+			return nil
+		}
+		return m.MarshalLogObject(e.Encoder)
+	}
+
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return nil
+	}
+	if e.deferField(zap.Object(key, obj)) {
+		return nil
+	}
+	if e.opts.maxObjectDepth > 0 {
+		obj = depthObjectMarshaler{obj: obj, depth: 1, max: e.opts.maxObjectDepth}
+	}
+	return e.Encoder.AddObject(key, safeObjectMarshaler{obj: obj})
+}
+
+// AddBinary implements zapcore.ObjectEncoder.
+func (e *Encoder) AddBinary(key string, value []byte) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Binary(key, value)) {
+		e.Encoder.AddBinary(key, value)
+	}
+}
+
+// AddByteString implements zapcore.ObjectEncoder.
+func (e *Encoder) AddByteString(key string, value []byte) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if n := e.opts.maxFieldValueLength; n > 0 && len(value) > n {
+		value = []byte(truncateString(string(value), n))
+	}
+	if !e.deferField(zap.ByteString(key, value)) {
+		e.Encoder.AddByteString(key, value)
+	}
+}
+
+// AddBool implements zapcore.ObjectEncoder.
+func (e *Encoder) AddBool(key string, value bool) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Bool(key, value)) {
+		e.Encoder.AddBool(key, value)
+	}
+}
+
+// AddComplex128 implements zapcore.ObjectEncoder.
+func (e *Encoder) AddComplex128(key string, value complex128) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Complex128(key, value)) {
+		e.Encoder.AddComplex128(key, value)
+	}
+}
+
+// AddComplex64 implements zapcore.ObjectEncoder.
+func (e *Encoder) AddComplex64(key string, value complex64) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Complex64(key, value)) {
+		e.Encoder.AddComplex64(key, value)
+	}
+}
+
+// AddDuration implements zapcore.ObjectEncoder.
+func (e *Encoder) AddDuration(key string, value time.Duration) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Duration(key, value)) {
+		e.Encoder.AddDuration(key, value)
+	}
+}
+
+// AddFloat64 implements zapcore.ObjectEncoder.
+func (e *Encoder) AddFloat64(key string, value float64) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Float64(key, value)) {
+		e.Encoder.AddFloat64(key, value)
+	}
+}
+
+// AddFloat32 implements zapcore.ObjectEncoder.
+func (e *Encoder) AddFloat32(key string, value float32) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Float32(key, value)) {
+		e.Encoder.AddFloat32(key, value)
+	}
+}
+
+// AddInt implements zapcore.ObjectEncoder.
+func (e *Encoder) AddInt(key string, value int) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Int(key, value)) {
+		e.Encoder.AddInt(key, value)
+	}
+}
+
+// AddInt64 implements zapcore.ObjectEncoder.
+func (e *Encoder) AddInt64(key string, value int64) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Int64(key, value)) {
+		e.Encoder.AddInt64(key, value)
+	}
+}
+
+// AddInt32 implements zapcore.ObjectEncoder.
+func (e *Encoder) AddInt32(key string, value int32) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Int32(key, value)) {
+		e.Encoder.AddInt32(key, value)
+	}
+}
+
+// AddInt16 implements zapcore.ObjectEncoder.
+func (e *Encoder) AddInt16(key string, value int16) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Int16(key, value)) {
+		e.Encoder.AddInt16(key, value)
+	}
+}
+
+// AddInt8 implements zapcore.ObjectEncoder.
+func (e *Encoder) AddInt8(key string, value int8) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Int8(key, value)) {
+		e.Encoder.AddInt8(key, value)
+	}
+}
+
+// AddString implements zapcore.ObjectEncoder.
+func (e *Encoder) AddString(key, value string) {
+	if key == keyInsertID {
+		e.insertID = value
+	}
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if n := e.opts.maxFieldValueLength; n > 0 && len(value) > n {
+		value = truncateString(value, n)
+	}
+	if !e.deferField(zap.String(key, value)) {
+		e.Encoder.AddString(key, value)
+	}
+}
+
+// AddTime implements zapcore.ObjectEncoder.
+func (e *Encoder) AddTime(key string, value time.Time) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Time(key, value)) {
+		e.Encoder.AddTime(key, value)
+	}
+}
+
+// AddUint implements zapcore.ObjectEncoder.
+func (e *Encoder) AddUint(key string, value uint) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Uint(key, value)) {
+		e.Encoder.AddUint(key, value)
+	}
+}
+
+// AddUint64 implements zapcore.ObjectEncoder.
+func (e *Encoder) AddUint64(key string, value uint64) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Uint64(key, value)) {
+		e.Encoder.AddUint64(key, value)
+	}
+}
+
+// AddUint32 implements zapcore.ObjectEncoder.
+func (e *Encoder) AddUint32(key string, value uint32) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Uint32(key, value)) {
+		e.Encoder.AddUint32(key, value)
+	}
+}
+
+// AddUint16 implements zapcore.ObjectEncoder.
+func (e *Encoder) AddUint16(key string, value uint16) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Uint16(key, value)) {
+		e.Encoder.AddUint16(key, value)
+	}
+}
+
+// AddUint8 implements zapcore.ObjectEncoder.
+func (e *Encoder) AddUint8(key string, value uint8) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Uint8(key, value)) {
+		e.Encoder.AddUint8(key, value)
+	}
+}
+
+// AddUintptr implements zapcore.ObjectEncoder.
+func (e *Encoder) AddUintptr(key string, value uintptr) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return
+	}
+	if !e.deferField(zap.Uintptr(key, value)) {
+		e.Encoder.AddUintptr(key, value)
+	}
+}
+
+// AddReflected implements zapcore.ObjectEncoder.
+func (e *Encoder) AddReflected(key string, value interface{}) error {
+	if m, ok := e.promotedLabels(zap.Reflect(key, value)); ok {
+		e.labels = mergeLabels(e.labels, m)
+		return nil
+	}
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		return nil
+	}
+	if e.deferField(zap.Reflect(key, value)) {
+		return nil
+	}
+	if f, ok := nativeSlice(zap.Reflect(key, value)); ok {
+		f.AddTo(e.Encoder)
+		return nil
+	}
+	if e.opts.noHTMLEscape || e.opts.newReflectedEncoder != nil {
+		if f, ok := replayReflected(key, value, e.opts.newReflectedEncoder); ok {
+			if e.opts.maxObjectDepth > 0 {
+				f = limitDepth(f, e.opts.maxObjectDepth)
+			}
+			f.AddTo(e.Encoder)
+			return nil
+		}
+	}
+	if e.opts.maxObjectDepth > 0 {
+		if f := limitDepth(zap.Reflect(key, value), e.opts.maxObjectDepth); f.Type != zapcore.ReflectType {
+			f.AddTo(e.Encoder)
+			return nil
+		}
+	}
+	return e.Encoder.AddReflected(key, value)
+}
+
+// OpenNamespace implements zapcore.ObjectEncoder.
+func (e *Encoder) OpenNamespace(key string) {
+	key = e.transformKey(key)
+	if !e.allowedKey(key) {
+		e.droppedNamespace = true
+		return
+	}
+	e.namespaced = true
+	if !e.deferField(zap.Namespace(key)) {
+		e.Encoder.OpenNamespace(key)
+	}
+}
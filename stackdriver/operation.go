@@ -0,0 +1,175 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+)
+
+const (
+	keyOperation = "logging.googleapis.com/operation"
+)
+
+// Operation additional information about a potentially long-running operation with which a log entry is associated.
+//
+//  https://cloud.google.com/logging/docs/reference/v2/rest/v2/LogEntry#logentryoperation
+type Operation struct {
+	// Optional. An arbitrary operation identifier. Log entries with the same
+	// identifier are assumed to be part of the same operation.
+	ID string `json:"id"`
+
+	// Optional. An arbitrary producer identifier. The combination of id and
+	// producer must be globally unique.
+	//
+	// Examples for producer: "MyDivision.MyBigCompany.com", "github.com/MyProject/MyApplication".
+	Producer string `json:"producer"`
+
+	// Optional. Set this to True if this is the first log entry in the operation.
+	First bool `json:"first"`
+
+	// Optional. Set this to True if this is the last log entry in the operation.
+	Last bool `json:"last"`
+}
+
+// IDGenerator generates the unique operation IDs.
+type IDGenerator interface {
+	// New returns a new unique ID.
+	New() string
+}
+
+// NewOperation returns a new Operation whose ID is generated by gen.
+func NewOperation(gen IDGenerator) *Operation {
+	return &Operation{
+		ID: gen.New(),
+	}
+}
+
+// Clone returns a copy of op.
+func (op *Operation) Clone() *Operation {
+	return &Operation{
+		ID:       op.ID,
+		Producer: op.Producer,
+		First:    op.First,
+		Last:     op.Last,
+	}
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (op *Operation) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("id", op.ID)
+	enc.AddString("producer", op.Producer)
+	enc.AddBool("first", op.First)
+	enc.AddBool("last", op.Last)
+
+	return nil
+}
+
+// Begin returns the field for the first log entry of the operation.
+func (op *Operation) Begin() zapcore.Field {
+	return op.field(true, false)
+}
+
+// Field returns the field for the intermediate log entries of the operation.
+func (op *Operation) Field() zapcore.Field {
+	return op.field(false, false)
+}
+
+// End returns the field for the last log entry of the operation.
+func (op *Operation) End() zapcore.Field {
+	return op.field(false, true)
+}
+
+func (op *Operation) field(first, last bool) zapcore.Field {
+	o := op.Clone()
+	o.First = first
+	o.Last = last
+
+	return zap.Object(keyOperation, o)
+}
+
+// WithOperation adds the Stackdriver "operation" field, which groups the entries of a
+// long-running operation, and sets the operation of the entry delivered to Stackdriver.
+//
+// The operation added by the zap.Logger.With is merged with the one of each entry: the non-empty
+// id and producer of the entry win, and first and last are set if either of them sets them.
+func WithOperation(id, producer string, first, last bool) zapcore.Field {
+	return zap.Object(keyOperation, &Operation{
+		ID:       id,
+		Producer: producer,
+		First:    first,
+		Last:     last,
+	})
+}
+
+// merge returns op merged with the operation of the entry, see WithOperation. Either of them
+// may be nil.
+func (op *Operation) merge(entry *Operation) *Operation {
+	switch {
+	case op == nil:
+		return entry
+	case entry == nil:
+		return op
+	}
+
+	merged := op.Clone()
+	if entry.ID != "" {
+		merged.ID = entry.ID
+	}
+	if entry.Producer != "" {
+		merged.Producer = entry.Producer
+	}
+	merged.First = merged.First || entry.First
+	merged.Last = merged.Last || entry.Last
+
+	return merged
+}
+
+// proto returns op as the operation of the sdlogging.Entry.
+func (op *Operation) proto() *logpb.LogEntryOperation {
+	return &logpb.LogEntryOperation{
+		Id:       op.ID,
+		Producer: op.Producer,
+		First:    op.First,
+		Last:     op.Last,
+	}
+}
+
+// extractOperation removes the operation fields, and returns their operations merged in order.
+func extractOperation(fields []zapcore.Field) ([]zapcore.Field, *Operation) {
+	var (
+		output []zapcore.Field
+		op     *Operation
+	)
+	for i, f := range fields {
+		o, ok := asOperation(f)
+		if !ok {
+			if output != nil {
+				output = append(output, f)
+			}
+			continue
+		}
+		if output == nil {
+			output = make([]zapcore.Field, i, len(fields))
+			copy(output, fields[:i])
+		}
+		op = op.merge(o)
+	}
+	if output == nil {
+		return fields, nil
+	}
+
+	return output, op
+}
+
+func asOperation(f zapcore.Field) (*Operation, bool) {
+	if f.Key != keyOperation || f.Type != zapcore.ObjectMarshalerType {
+		return nil, false
+	}
+	op, ok := f.Interface.(*Operation)
+
+	return op, ok && op != nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/zchee/zap-encoder/internal/testutil"
+	"github.com/zchee/zap-encoder/internal/uid"
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+func TestNewOperation(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel)
+
+	op := stackdriver.NewOperation(uid.NewSpace("job", nil))
+	op.Producer = "github.com/zchee/zap-encoder"
+	logger.Info("begin", op.Begin())
+	logger.Info("step", op.Field())
+	logger.Info("end", op.End())
+
+	entries := lg.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+
+	tests := []struct {
+		first, last bool
+	}{
+		{first: true, last: false},
+		{first: false, last: false},
+		{first: false, last: true},
+	}
+	for i, tt := range tests {
+		got, ok := decodePayload(t, entries[i])["logging.googleapis.com/operation"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("entry %d: operation field is missing", i)
+		}
+		if got["id"] != op.ID {
+			t.Errorf("entry %d: got id %v, want %s", i, got["id"], op.ID)
+		}
+		if got["producer"] != op.Producer {
+			t.Errorf("entry %d: got producer %v, want %s", i, got["producer"], op.Producer)
+		}
+		if got["first"] != tt.first || got["last"] != tt.last {
+			t.Errorf("entry %d: got first=%v last=%v, want first=%t last=%t", i, got["first"], got["last"], tt.first, tt.last)
+		}
+	}
+	if op.First || op.Last {
+		t.Errorf("operation was modified: %+v", op)
+	}
+}
+
+func TestWithOperation(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel).
+		With(stackdriver.WithOperation("op-1", "github.com/zchee/zap-encoder", false, false))
+	logger.Info("begin", stackdriver.WithOperation("", "", true, false))
+	logger.Info("step")
+	logger.Info("end", stackdriver.WithOperation("", "", false, true))
+
+	tests := []struct {
+		first, last bool
+	}{
+		{first: true, last: false},
+		{first: false, last: false},
+		{first: false, last: true},
+	}
+	entries := lg.Entries()
+	for i, tt := range tests {
+		op := entries[i].Operation
+		if op == nil {
+			t.Fatalf("entry %d: got no operation", i)
+		}
+		if op.Id != "op-1" || op.Producer != "github.com/zchee/zap-encoder" {
+			t.Errorf("entry %d: got id %q producer %q, want the operation of With", i, op.Id, op.Producer)
+		}
+		if op.First != tt.first || op.Last != tt.last {
+			t.Errorf("entry %d: got first=%t last=%t, want first=%t last=%t", i, op.First, op.Last, tt.first, tt.last)
+		}
+
+		got, ok := decodePayload(t, entries[i])["logging.googleapis.com/operation"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("entry %d: operation field is missing", i)
+		}
+		if got["id"] != "op-1" || got["first"] != tt.first || got["last"] != tt.last {
+			t.Errorf("entry %d: got operation field %v", i, got)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"io"
+	"time"
+
+	sdlogging "cloud.google.com/go/logging"
+	"go.uber.org/zap/zapcore"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+// Option configures the Encoder returned by NewStackdriverEncoder.
+type Option func(*options)
+
+// options holds the optional Encoder settings. It is shared by the cloned
+// encoders and must not be modified after the Encoder is created.
+type options struct {
+	maxFieldValueLength int
+	sourceLocation      bool
+	sourceLocationAll   bool
+	projectIDResolver   func(zapcore.Entry, []zapcore.Field) string
+	newProjectLogger    LoggerFactory
+	projectLoggers      *loggerCache
+	fallback            io.Writer
+	contextPrefix       string
+	loggerThresholds    map[string]zapcore.Level
+	labels              map[string]string
+	labelLimits         labelLimits
+	loggerNameLabel     string
+	entryFilter         func(zapcore.Entry, []zapcore.Field) bool
+	disabled            bool
+	severityFloor       *int32 // atomic
+	errorHandler        func(error)
+	sourceFilePrefix    string
+	clock               func() time.Time
+	dedupe              DedupePolicy
+	keyTransformer      func(string) string
+	timestampAlias      string
+	maxObjectDepth      int
+	receiveTimestamp    bool
+	messageTemplate     *messageTemplate
+	durationEncoder     zapcore.DurationEncoder
+	stats               *encoderStats
+	structuredStack     bool
+	defaultSeverity     sdlogging.Severity
+	promoteLabels       bool
+	noHTMLEscape        bool
+	newReflectedEncoder func(io.Writer) ReflectedEncoder
+	severityMap         map[zapcore.Level]sdlogging.Severity
+	logContextKey       interface{}
+	syncTimeout         time.Duration
+	fieldAllowlist      map[string]bool
+	timestampPrecision  time.Duration
+	// sourceLocationInPayload keeps the source location in the payload.
+	sourceLocationInPayload bool
+	// levelKey is the JSON-quoted level key followed by the colon, and levelEncoder encodes the
+	// levels of the default mapping by the EncodeLevel of the EncoderConfig, see appendLevel.
+	levelKey     string
+	levelEncoder zapcore.Encoder
+}
+
+// WithMaxFieldValueLength truncates any string, byte string or fmt.Stringer field
+// value longer than n bytes to n bytes and marks it with an ellipsis, including
+// the fields added by the zap.Logger.With. A zero or negative n disables the
+// truncation.
+func WithMaxFieldValueLength(n int) Option {
+	return func(o *options) {
+		o.maxFieldValueLength = n
+	}
+}
+
+// WithSourceLocation attaches the structured "logging.googleapis.com/sourceLocation" field,
+// built from the entry caller, to the entries at ErrorLevel and above. The source location
+// passed with the entry, e.g. by LogSourceLocation, is kept rather than the caller.
+func WithSourceLocation(enable bool) Option {
+	return func(o *options) {
+		o.sourceLocation = enable
+	}
+}
+
+// WithAlwaysSourceLocation attaches the structured "logging.googleapis.com/sourceLocation" field,
+// built from the entry caller, to the entries at every level. See WithSourceLocation.
+func WithAlwaysSourceLocation(enable bool) Option {
+	return func(o *options) {
+		o.sourceLocationAll = enable
+	}
+}
+
+// WithSourceFileTrim trims prefix, e.g. the module root on the build host, from the file paths
+// of the "sourceLocation" and "context.reportLocation" fields, so they are relative to the
+// module root and don't leak the build host paths. The paths without prefix are kept as is.
+func WithSourceFileTrim(prefix string) Option {
+	return func(o *options) {
+		o.sourceFilePrefix = prefix
+	}
+}
+
+// WithProjectIDResolver routes each entry to the project returned by resolve. The
+// Logger for each project is created by newLogger on first use and cached for the
+// lifetime of the Encoder. The entries for which resolve returns the empty string
+// are delivered to the Encoder's default Logger.
+//
+// If newLogger fails, the error is passed to the WithErrorHandler handler and the entry
+// is delivered to the default Logger. The error is cached, and newLogger is called for
+// the project again once a minute has elapsed.
+func WithProjectIDResolver(resolve func(zapcore.Entry, []zapcore.Field) string, newLogger LoggerFactory) Option {
+	return func(o *options) {
+		o.projectIDResolver = resolve
+		o.newProjectLogger = newLogger
+	}
+}
+
+// WithConsoleFallback writes the entries as JSON lines to w instead of delivering them
+// to Stackdriver when no Logger is available, e.g. the logging client could not be
+// created because the credentials are absent. It also switches to w for good once
+// the Logger fails to flush several times in a row.
+//
+// The failed flushes are only detected by Sync and by the entries at PanicLevel, DPanicLevel
+// and FatalLevel, so Sync must be called regularly, unless Encoder.OnError is set as the
+// logging client error handler, which counts the failures of the background delivery too.
+func WithConsoleFallback(w io.Writer) Option {
+	return func(o *options) {
+		o.fallback = w
+	}
+}
+
+// WithContextKeys moves every field whose key starts with prefix, e.g. "context.", into the
+// nested "context" object under the rest of the key. Without it, only the "context.user",
+// "context.httpRequest" and "context.reportLocation" fields are moved.
+func WithContextKeys(prefix string) Option {
+	return func(o *options) {
+		o.contextPrefix = prefix
+	}
+}
+
+// WithLoggerThresholds sets the minimum level delivered to Stackdriver for each logger name.
+// The entries below the threshold of their logger are still encoded, but not delivered.
+// The loggers not in thresholds deliver every entry.
+func WithLoggerThresholds(thresholds map[string]zapcore.Level) Option {
+	return func(o *options) {
+		o.loggerThresholds = make(map[string]zapcore.Level, len(thresholds))
+		for name, lv := range thresholds {
+			o.loggerThresholds[name] = lv
+		}
+	}
+}
+
+// WithEntryFilter drops the entries for which keep returns false. The dropped entries
+// are neither encoded nor delivered to Stackdriver, regardless of their level.
+func WithEntryFilter(keep func(zapcore.Entry, []zapcore.Field) bool) Option {
+	return func(o *options) {
+		o.entryFilter = keep
+	}
+}
+
+// WithDisabled disables the delivery to Stackdriver, e.g. in the local development. The entries
+// are still encoded, so the other outputs of the zapcore.Core keep working.
+func WithDisabled(disabled bool) Option {
+	return func(o *options) {
+		o.disabled = disabled
+	}
+}
+
+// WithSeverityFloor sets the initial minimum level delivered to Stackdriver, which can be
+// changed at runtime by Encoder.SetSeverityFloor. The entries below the floor are still
+// encoded, but not delivered. By default, every level is delivered, including the custom
+// levels below DebugLevel.
+func WithSeverityFloor(lv zapcore.Level) Option {
+	return func(o *options) {
+		floor := int32(lv)
+		o.severityFloor = &floor
+	}
+}
+
+// WithErrorHandler sets fn as the handler of the errors detected by the Encoder before the
+// delivery, e.g. the PayloadTooLargeError or the LoggerFactory error of WithProjectIDResolver.
+// The errors aren't returned by EncodeEntry, so the entry is still written by the zapcore.Core.
+func WithErrorHandler(fn func(error)) Option {
+	return func(o *options) {
+		o.errorHandler = fn
+	}
+}
+
+// WithClock sets the clock which stamps the entries arriving with the zero time, which
+// Stackdriver would otherwise reject or back-date. Defaults to time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.clock = now
+	}
+}
+
+// WithTimestampAlias additionally writes the entry time under key, e.g. "timestamp", next to the
+// time key of the EncoderConfig, so the existing queries on the old key keep working during the
+// migration. The time is encoded by the EncodeTime of the EncoderConfig.
+func WithTimestampAlias(key string) Option {
+	return func(o *options) {
+		o.timestampAlias = key
+	}
+}
+
+// WithTimestampPrecision rounds the entry time to the nearest multiple of d, e.g. time.Second,
+// for the privacy or the cardinality. The rounded time is used for both the time key of the
+// EncoderConfig and the timestamp of the entry delivered to Stackdriver. A zero or negative d
+// keeps the time as is.
+func WithTimestampPrecision(d time.Duration) Option {
+	return func(o *options) {
+		o.timestampPrecision = d
+	}
+}
+
+// WithReceiveTimestamp adds the "receiveTimestamp" field, which is the time the Encoder processed
+// the entry by its clock, so the latency between the entry creation and the ingestion can be
+// analyzed. See WithClock.
+func WithReceiveTimestamp(enable bool) Option {
+	return func(o *options) {
+		o.receiveTimestamp = enable
+	}
+}
+
+// WithDurationEncoding sets the encoding of the duration fields by unit: "s" encodes them as the
+// floating-point seconds, "ms" as the integer milliseconds and "ns" as the integer nanoseconds.
+// The other units are ignored. Defaults to the EncodeDuration of the EncoderConfig.
+func WithDurationEncoding(unit string) Option {
+	var enc zapcore.DurationEncoder
+	switch unit {
+	case "s":
+		enc = zapcore.SecondsDurationEncoder
+	case "ms":
+		enc = millisDurationEncoder
+	case "ns":
+		enc = zapcore.NanosDurationEncoder
+	}
+
+	return func(o *options) {
+		if enc != nil {
+			o.durationEncoder = enc
+		}
+	}
+}
+
+// millisDurationEncoder serializes a time.Duration to an integer number of milliseconds elapsed.
+func millisDurationEncoder(d time.Duration, enc zapcore.PrimitiveArrayEncoder) {
+	enc.AppendInt64(int64(d / time.Millisecond))
+}
+
+// WithDefaultSeverity sets the severity of the entries whose level has no Stackdriver severity,
+// e.g. the entries bridged from the standard log package with a custom level, which are
+// otherwise delivered with sdlogging.Default. The severity is used for both the entry delivered
+// to Stackdriver and the "severity" field of the payload.
+func WithDefaultSeverity(sev sdlogging.Severity) Option {
+	return func(o *options) {
+		o.defaultSeverity = sev
+	}
+}
+
+// WithSeverityMap overrides the Stackdriver severity of the levels in severities, e.g. a custom
+// NoticeLevel between InfoLevel and WarnLevel mapped to sdlogging.Notice. The severity is used
+// for both the entry delivered to Stackdriver and the "severity" field of the payload, which is
+// the upper-cased severity name. The other levels keep the default mapping.
+func WithSeverityMap(severities map[zapcore.Level]sdlogging.Severity) Option {
+	return func(o *options) {
+		o.severityMap = make(map[zapcore.Level]sdlogging.Severity, len(severities))
+		for lv, sev := range severities {
+			o.severityMap[lv] = sev
+		}
+	}
+}
+
+// WithSyncTimeout bounds the time the Sync of the zap.Logger returned by NewLogger waits for the
+// buffered entries to be delivered, e.g. at the shutdown. Sync returns ErrSyncTimeout once d
+// elapses. A zero or negative d waits until the delivery completes, which is the default.
+func WithSyncTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.syncTimeout = d
+	}
+}
+
+// ClientOption configures the client returned by NewDefaultStackdriverClient.
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	onError         func(error)
+	noThrowawaySpan bool
+	logName         string
+	resource        *mrpb.MonitoredResource
+}
+
+// WithOnError sets fn as the logging client error handler. The identical error
+// messages are passed to fn at most once per interval, see RateLimitErrors.
+//
+// By default, the client errors are discarded.
+func WithOnError(fn func(error), interval time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.onError = RateLimitErrors(fn, interval)
+	}
+}
+
+// WithoutThrowawaySpan supplies the logging client calls with a plain timeout context, instead
+// of starting a never-sampled span for each call, which adds overhead and shows up oddly in some
+// tracing setups.
+func WithoutThrowawaySpan() ClientOption {
+	return func(o *clientOptions) {
+		o.noThrowawaySpan = true
+	}
+}
+
+// WithLogName writes the entries to the fully-qualified log name, e.g.
+// "projects/other-project/logs/app", instead of the logID in the project of the client, so a
+// service can write to the log of another project it has the IAM permission on. The entries
+// without a detected or common resource are attributed to the global resource of that project.
+// A name which isn't fully-qualified is ignored.
+func WithLogName(name string) ClientOption {
+	return func(o *clientOptions) {
+		o.logName = name
+	}
+}
+
+// WithCommonResource sets the monitored resource of the entries written by the client, instead
+// of the one detected by DetectResource, e.g. to inject a fixed resource in tests. The entries
+// can still override it, see WithMonitoredResource.
+func WithCommonResource(r *mrpb.MonitoredResource) ClientOption {
+	return func(o *clientOptions) {
+		o.resource = r
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"bytes"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// protoMarshaler is the ObjectMarshaler of the field returned by WithProto.
+type protoMarshaler struct {
+	msg proto.Message
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (p protoMarshaler) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	var buf bytes.Buffer
+	if err := (&jsonpb.Marshaler{}).Marshal(&buf, p.msg); err != nil {
+		return err
+	}
+	obj, err := decodeJSONObject(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	return obj.MarshalLogObject(enc)
+}
+
+// WithProto adds msg as the nested object marshaled by the proto3 JSON mapping, which names
+// the fields in lowerCamelCase and writes the enums as strings.
+//
+//  https://developers.google.com/protocol-buffers/docs/proto3#json
+func WithProto(key string, msg proto.Message) zapcore.Field {
+	if msg == nil {
+		return zap.Skip()
+	}
+
+	return zap.Object(key, protoMarshaler{msg: msg})
+}
@@ -0,0 +1,37 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+	ltype "google.golang.org/genproto/googleapis/logging/type"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+
+	"github.com/zchee/zap-encoder/internal/testutil"
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+func TestWithProto(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel)
+
+	logger.Info("msg", stackdriver.WithProto("entry", &logpb.LogEntry{
+		LogName:  "projects/p/logs/l",
+		InsertId: "abc",
+		Severity: ltype.LogSeverity_ERROR,
+		Labels:   map[string]string{"b": "2", "a": "1"},
+		Payload:  &logpb.LogEntry_TextPayload{TextPayload: "<text>"},
+	}))
+
+	payload := lg.Entries()[0].Payload.(string)
+	want := `"entry":{"logName":"projects/p/logs/l","textPayload":"<text>","severity":"ERROR","insertId":"abc","labels":{"a":"1","b":"2"}}`
+	if !strings.Contains(payload, want) {
+		t.Errorf("got %s, want %s", payload, want)
+	}
+}
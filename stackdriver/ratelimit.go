@@ -0,0 +1,68 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"sync"
+	"time"
+)
+
+// maxTrackedErrors is the number of distinct error messages after which the
+// expired messages are forgotten.
+const maxTrackedErrors = 1024
+
+// RateLimitErrors returns an error handler which passes each distinct error message
+// to fn at most once per interval, and drops the identical errors in between.
+//
+// It is useful as the sdlogging.Client.OnError to avoid a storm of identical errors
+// while the Stackdriver logging service is unreachable.
+func RateLimitErrors(fn func(error), interval time.Duration) func(error) {
+	return newErrorLimiter(fn, interval, time.Now).handle
+}
+
+// errorLimiter rate limits the identical error messages.
+type errorLimiter struct {
+	fn       func(error)
+	interval time.Duration
+	now      func() time.Time
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newErrorLimiter(fn func(error), interval time.Duration, now func() time.Time) *errorLimiter {
+	return &errorLimiter{
+		fn:       fn,
+		interval: interval,
+		now:      now,
+		last:     make(map[string]time.Time),
+	}
+}
+
+func (l *errorLimiter) handle(err error) {
+	if err == nil {
+		return
+	}
+
+	msg := err.Error()
+	now := l.now()
+
+	l.mu.Lock()
+	if last, ok := l.last[msg]; ok && now.Sub(last) < l.interval {
+		l.mu.Unlock()
+		return
+	}
+	if len(l.last) >= maxTrackedErrors {
+		for m, last := range l.last {
+			if now.Sub(last) >= l.interval {
+				delete(l.last, m)
+			}
+		}
+	}
+	l.last[msg] = now
+	l.mu.Unlock()
+
+	l.fn(err)
+}
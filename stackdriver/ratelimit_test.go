@@ -0,0 +1,46 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+func TestRateLimitErrors(t *testing.T) {
+	now := time.Date(2018, 6, 19, 16, 33, 42, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	got := make(map[string]int)
+	handler := stackdriver.RateLimitErrorsWithClock(func(err error) {
+		got[err.Error()]++
+	}, 10*time.Second, clock)
+
+	// simulate a minute of continuous delivery failures, one per second.
+	unavailable := errors.New("rpc error: code = Unavailable")
+	for i := 0; i < 60; i++ {
+		handler(unavailable)
+		handler(errors.New(unavailable.Error()))
+		now = now.Add(time.Second)
+	}
+	handler(errors.New("rpc error: code = PermissionDenied"))
+	handler(nil)
+
+	want := map[string]int{
+		"rpc error: code = Unavailable":      6,
+		"rpc error: code = PermissionDenied": 1,
+	}
+	for msg, n := range want {
+		if got[msg] != n {
+			t.Errorf("%q: got %d calls, want %d", msg, got[msg], n)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("unexpected errors: %v", got)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// jsonObject is a JSON object decoded in the member order, which marshals back into the
+// zapcore.ObjectEncoder as it was, since zap can't append the raw JSON.
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	key   string
+	value interface{} // string, json.Number, bool, nil, jsonObject or jsonArray
+}
+
+// jsonArray is a JSON array decoded in order, see jsonObject.
+type jsonArray []interface{}
+
+// decodeJSONObject decodes data, which must be a JSON object.
+func decodeJSONObject(data []byte) (jsonObject, error) {
+	v, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := v.(jsonObject)
+	if !ok {
+		return nil, fmt.Errorf("stackdriver: JSON %T is not an object", v)
+	}
+
+	return obj, nil
+}
+
+// decodeJSON decodes data into a JSON value, which is a string, json.Number, bool, nil,
+// jsonObject or jsonArray.
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	v, err := decodeJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("stackdriver: trailing data after the JSON value")
+	}
+
+	return v, nil
+}
+
+func decodeJSONValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		obj := jsonObject{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, jsonMember{key: key.(string), value: value})
+		}
+		if _, err := dec.Token(); err != nil { // '}'
+			return nil, err
+		}
+		return obj, nil
+
+	case json.Delim('['):
+		arr := jsonArray{}
+		for dec.More() {
+			value, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		if _, err := dec.Token(); err != nil { // ']'
+			return nil, err
+		}
+		return arr, nil
+	}
+
+	return tok, nil
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (obj jsonObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, m := range obj {
+		var err error
+		switch v := m.value.(type) {
+		case string:
+			enc.AddString(m.key, v)
+		case bool:
+			enc.AddBool(m.key, v)
+		case json.Number:
+			if i, ierr := v.Int64(); ierr == nil {
+				enc.AddInt64(m.key, i)
+			} else if f, ferr := v.Float64(); ferr == nil {
+				enc.AddFloat64(m.key, f)
+			} else {
+				enc.AddString(m.key, v.String())
+			}
+		case jsonObject:
+			err = enc.AddObject(m.key, v)
+		case jsonArray:
+			err = enc.AddArray(m.key, v)
+		default:
+			err = enc.AddReflected(m.key, v) // null
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MarshalLogArray implements zapcore.ArrayMarshaler.
+func (arr jsonArray) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, value := range arr {
+		var err error
+		switch v := value.(type) {
+		case string:
+			enc.AppendString(v)
+		case bool:
+			enc.AppendBool(v)
+		case json.Number:
+			if i, ierr := v.Int64(); ierr == nil {
+				enc.AppendInt64(i)
+			} else if f, ferr := v.Float64(); ferr == nil {
+				enc.AppendFloat64(f)
+			} else {
+				enc.AppendString(v.String())
+			}
+		case jsonObject:
+			err = enc.AppendObject(v)
+		case jsonArray:
+			err = enc.AppendArray(v)
+		default:
+			err = enc.AppendReflected(v) // null
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replayReflected returns the field of the reflected value v, which is marshaled into JSON by
+// newEncoder and replayed from the decoded JSON value, and reports whether v is marshaled.
+func replayReflected(key string, v interface{}, newEncoder func(io.Writer) ReflectedEncoder) (zapcore.Field, bool) {
+	data, err := marshalReflected(v, newEncoder)
+	if err != nil {
+		return zapcore.Field{}, false
+	}
+	value, err := decodeJSON(data)
+	if err != nil {
+		return zapcore.Field{}, false
+	}
+
+	switch value := value.(type) {
+	case jsonObject:
+		return zap.Object(key, value), true
+	case jsonArray:
+		return zap.Array(key, value), true
+	case string:
+		return zap.String(key, value), true
+	case bool:
+		return zap.Bool(key, value), true
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return zap.Int64(key, i), true
+		}
+		if f, err := value.Float64(); err == nil {
+			return zap.Float64(key, f), true
+		}
+	}
+
+	return zapcore.Field{}, false
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// ReflectedEncoder serializes the reflected field values into JSON. *json.Encoder implements
+// ReflectedEncoder.
+type ReflectedEncoder interface {
+	Encode(v interface{}) error
+}
+
+//pragma: compiler time checks whether the json.Encoder implemented ReflectedEncoder interface.
+var _ ReflectedEncoder = (*json.Encoder)(nil)
+
+// WithReflectedEncoder serializes the reflected fields, e.g. the ones of zap.Reflect and
+// zap.Any of a struct, with the ReflectedEncoder returned by newEncoder instead of
+// encoding/json, e.g. for speed or to render some types specially. newEncoder is called for
+// each value with the writer to encode it into.
+//
+// The JSON written by the ReflectedEncoder is decoded and replayed into the Encoder, so it
+// must be valid.
+func WithReflectedEncoder(newEncoder func(io.Writer) ReflectedEncoder) Option {
+	return func(o *options) {
+		o.newReflectedEncoder = newEncoder
+	}
+}
+
+// WithDisableHTMLEscape stops escaping '<', '>' and '&' as "\u003c", "\u003e" and "\u0026" in the
+// reflected fields, e.g. the URLs in a struct logged by zap.Any, which are ugly in the Logs
+// Explorer. The other fields are never HTML-escaped by zap.
+//
+// The reflected fields are marshaled into JSON and decoded before being encoded.
+func WithDisableHTMLEscape(disable bool) Option {
+	return func(o *options) {
+		o.noHTMLEscape = disable
+	}
+}
+
+// marshalReflected marshals v into JSON by the ReflectedEncoder returned by newEncoder, or
+// encoding/json if it's nil.
+func marshalReflected(v interface{}, newEncoder func(io.Writer) ReflectedEncoder) ([]byte, error) {
+	if newEncoder == nil {
+		return json.Marshal(v)
+	}
+
+	var buf bytes.Buffer
+	if err := newEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// replayReflectedFields returns the fields whose reflected values are marshaled by newEncoder
+// and replayed from their JSON, see replayReflected. The fields slice is copied on the first
+// change.
+func replayReflectedFields(fields []zapcore.Field, newEncoder func(io.Writer) ReflectedEncoder) []zapcore.Field {
+	var output []zapcore.Field
+	for i, f := range fields {
+		if f.Type != zapcore.ReflectType {
+			continue
+		}
+		replayed, ok := replayReflected(f.Key, f.Interface, newEncoder)
+		if !ok {
+			continue
+		}
+		if output == nil {
+			output = make([]zapcore.Field, len(fields))
+			copy(output, fields)
+		}
+		output[i] = replayed
+	}
+	if output == nil {
+		return fields
+	}
+
+	return output
+}
@@ -0,0 +1,180 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/compute/metadata"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+const (
+	// keyMonitoredResource is the key of the field returned by WithMonitoredResource. The
+	// Encoder delivers the resource with the entry instead of encoding the field.
+	keyMonitoredResource = "logging.googleapis.com/resource"
+)
+
+// monitoredResource is the ObjectMarshaler of the field returned by WithMonitoredResource.
+type monitoredResource struct {
+	*mrpb.MonitoredResource
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (r monitoredResource) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("type", r.Type)
+	if len(r.Labels) > 0 {
+		return enc.AddObject("labels", stringMap(r.Labels))
+	}
+
+	return nil
+}
+
+// WithMonitoredResource overrides the monitored resource of the entry, which defaults to the
+// common resource of the Logger, e.g. in a sidecar process logging for several services.
+//
+// The resource is delivered with the entry rather than encoded in the payload.
+func WithMonitoredResource(r *mrpb.MonitoredResource) zapcore.Field {
+	if r == nil {
+		return zap.Skip()
+	}
+
+	return zap.Object(keyMonitoredResource, monitoredResource{r})
+}
+
+// extractMonitoredResource removes the fields returned by WithMonitoredResource, and returns
+// the last resource found.
+func extractMonitoredResource(fields []zapcore.Field) ([]zapcore.Field, *mrpb.MonitoredResource) {
+	var (
+		output   []zapcore.Field
+		resource *mrpb.MonitoredResource
+	)
+	for i, f := range fields {
+		r, ok := asMonitoredResource(f)
+		if !ok {
+			if output != nil {
+				output = append(output, f)
+			}
+			continue
+		}
+		if output == nil {
+			output = make([]zapcore.Field, i, len(fields))
+			copy(output, fields[:i])
+		}
+		resource = r
+	}
+	if output == nil {
+		return fields, nil
+	}
+
+	return output, resource
+}
+
+func asMonitoredResource(f zapcore.Field) (*mrpb.MonitoredResource, bool) {
+	if f.Key != keyMonitoredResource || f.Type != zapcore.ObjectMarshalerType {
+		return nil, false
+	}
+	r, ok := f.Interface.(monitoredResource)
+
+	return r.MonitoredResource, ok
+}
+
+// resourceDetector detects the monitored resource of the running environment from the
+// environment variables and the metadata server. It is replaced in tests.
+type resourceDetector struct {
+	getenv   func(key string) string
+	onGCE    func() bool
+	metadata func(suffix string) (string, error)
+}
+
+var defaultResourceDetector = resourceDetector{
+	getenv:   os.Getenv,
+	onGCE:    metadata.OnGCE,
+	metadata: metadata.Get,
+}
+
+// DetectResource returns the monitored resource of the running environment: the
+// "cloud_run_revision" on Cloud Run, the "k8s_container" on GKE and the "gce_instance" on GCE.
+// It returns nil if the environment isn't recognized, or ctx is done or the metadata server
+// fails before the resource is complete.
+//
+// The GKE namespace, pod and container names are read from the NAMESPACE, POD_NAME (or
+// HOSTNAME) and CONTAINER_NAME environment variables, which are set by the Pod spec, e.g. with
+// the Downward API.
+func DetectResource(ctx context.Context) *mrpb.MonitoredResource {
+	return defaultResourceDetector.detect(ctx)
+}
+
+func (d resourceDetector) detect(ctx context.Context) *mrpb.MonitoredResource {
+	var err error
+	get := func(suffix string) string {
+		if err != nil {
+			return ""
+		}
+		if err = ctx.Err(); err != nil {
+			return ""
+		}
+		var v string
+		v, err = d.metadata(suffix)
+		return strings.TrimSpace(v)
+	}
+
+	var r *mrpb.MonitoredResource
+	switch {
+	case d.getenv("K_SERVICE") != "":
+		r = &mrpb.MonitoredResource{
+			Type: "cloud_run_revision",
+			Labels: map[string]string{
+				"project_id":         get("project/project-id"),
+				"location":           lastSegment(get("instance/region")),
+				"service_name":       d.getenv("K_SERVICE"),
+				"revision_name":      d.getenv("K_REVISION"),
+				"configuration_name": d.getenv("K_CONFIGURATION"),
+			},
+		}
+	case !d.onGCE():
+		return nil
+	case d.getenv("KUBERNETES_SERVICE_HOST") != "":
+		pod := d.getenv("POD_NAME")
+		if pod == "" {
+			pod = d.getenv("HOSTNAME")
+		}
+		r = &mrpb.MonitoredResource{
+			Type: "k8s_container",
+			Labels: map[string]string{
+				"project_id":     get("project/project-id"),
+				"location":       get("instance/attributes/cluster-location"),
+				"cluster_name":   get("instance/attributes/cluster-name"),
+				"namespace_name": d.getenv("NAMESPACE"),
+				"pod_name":       pod,
+				"container_name": d.getenv("CONTAINER_NAME"),
+			},
+		}
+	default:
+		r = &mrpb.MonitoredResource{
+			Type: "gce_instance",
+			Labels: map[string]string{
+				"project_id":  get("project/project-id"),
+				"instance_id": get("instance/id"),
+				"zone":        lastSegment(get("instance/zone")),
+			},
+		}
+	}
+	if err != nil {
+		return nil
+	}
+
+	return r
+}
+
+// lastSegment returns the last segment of the metadata resource path, e.g. the zone name of
+// "projects/123/zones/us-central1-a".
+func lastSegment(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/zap/zapcore"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+
+	"github.com/zchee/zap-encoder/internal/testutil"
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+func TestWithMonitoredResource(t *testing.T) {
+	frontend := &mrpb.MonitoredResource{
+		Type:   "cloud_run_revision",
+		Labels: map[string]string{"service_name": "frontend"},
+	}
+	backend := &mrpb.MonitoredResource{
+		Type:   "cloud_run_revision",
+		Labels: map[string]string{"service_name": "backend"},
+	}
+
+	lg := testutil.NewFakeLogger()
+	logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel)
+
+	logger.Info("msg", stackdriver.WithMonitoredResource(frontend))
+	logger.Info("msg", stackdriver.WithMonitoredResource(backend))
+	logger.With(stackdriver.WithMonitoredResource(backend)).Info("msg")
+	logger.Info("msg")
+
+	entries := lg.Entries()
+	if len(entries) != 4 {
+		t.Fatalf("got %d entries, want 4", len(entries))
+	}
+	for i, want := range []*mrpb.MonitoredResource{frontend, backend, backend, nil} {
+		if got := entries[i].Resource; !proto.Equal(got, want) {
+			t.Errorf("entry %d: got resource %v, want %v", i, got, want)
+		}
+		if _, ok := decodePayload(t, entries[i])["logging.googleapis.com/resource"]; ok {
+			t.Errorf("entry %d: the resource is encoded in the payload", i)
+		}
+	}
+}
+
+func TestDetectResource(t *testing.T) {
+	md := map[string]string{
+		"project/project-id":                   "my-project",
+		"instance/id":                          "1234",
+		"instance/zone":                        "projects/42/zones/us-central1-a",
+		"instance/region":                      "projects/42/regions/us-central1",
+		"instance/attributes/cluster-name":     "prod",
+		"instance/attributes/cluster-location": "us-central1",
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		env   map[string]string
+		onGCE bool
+		md    map[string]string
+		want  *mrpb.MonitoredResource
+	}{
+		{
+			name: "cloud run",
+			env:  map[string]string{"K_SERVICE": "api", "K_REVISION": "api-00042", "K_CONFIGURATION": "api"},
+			md:   md,
+			want: &mrpb.MonitoredResource{Type: "cloud_run_revision", Labels: map[string]string{
+				"project_id": "my-project", "location": "us-central1", "service_name": "api", "revision_name": "api-00042", "configuration_name": "api",
+			}},
+		},
+		{
+			name:  "gke",
+			env:   map[string]string{"KUBERNETES_SERVICE_HOST": "10.0.0.1", "NAMESPACE": "default", "HOSTNAME": "api-7d9f", "CONTAINER_NAME": "app"},
+			onGCE: true,
+			md:    md,
+			want: &mrpb.MonitoredResource{Type: "k8s_container", Labels: map[string]string{
+				"project_id": "my-project", "location": "us-central1", "cluster_name": "prod", "namespace_name": "default", "pod_name": "api-7d9f", "container_name": "app",
+			}},
+		},
+		{
+			name:  "gce",
+			onGCE: true,
+			md:    md,
+			want: &mrpb.MonitoredResource{Type: "gce_instance", Labels: map[string]string{
+				"project_id": "my-project", "instance_id": "1234", "zone": "us-central1-a",
+			}},
+		},
+		{name: "unknown"},
+		{name: "metadata failure", onGCE: true, md: map[string]string{}},
+		{name: "canceled", ctx: canceled, onGCE: true, md: md},
+	}
+	for _, tt := range tests {
+		ctx := tt.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		got := stackdriver.DetectResourceWith(ctx, tt.env, tt.onGCE, tt.md)
+		if !proto.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
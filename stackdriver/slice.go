@@ -0,0 +1,82 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// nativeSlice returns the array field of the reflected slice of primitives f, e.g. the []int
+// passed to zap.Any or zap.Reflect, which the JSON encoder would otherwise encode by
+// reflection. The nil slices, which are encoded as null, and the float slices, which
+// encoding/json formats differently, are left to the reflection.
+func nativeSlice(f zapcore.Field) (zapcore.Field, bool) {
+	if f.Type != zapcore.ReflectType {
+		return f, false
+	}
+
+	switch v := f.Interface.(type) {
+	case []string:
+		if v != nil {
+			return zap.Strings(f.Key, v), true
+		}
+	case []int:
+		if v != nil {
+			return zap.Ints(f.Key, v), true
+		}
+	case []int64:
+		if v != nil {
+			return zap.Int64s(f.Key, v), true
+		}
+	case []int32:
+		if v != nil {
+			return zap.Int32s(f.Key, v), true
+		}
+	case []uint:
+		if v != nil {
+			return zap.Uints(f.Key, v), true
+		}
+	case []uint64:
+		if v != nil {
+			return zap.Uint64s(f.Key, v), true
+		}
+	case []uint32:
+		if v != nil {
+			return zap.Uint32s(f.Key, v), true
+		}
+	case []bool:
+		if v != nil {
+			return zap.Bools(f.Key, v), true
+		}
+	}
+
+	return f, false
+}
+
+// nativeSliceFields returns the fields with the reflected slices of primitives replaced by the
+// array fields. See nativeSlice.
+func nativeSliceFields(fields []zapcore.Field) []zapcore.Field {
+	var output []zapcore.Field
+	for i, f := range fields {
+		nf, ok := nativeSlice(f)
+		if !ok {
+			if output != nil {
+				output = append(output, f)
+			}
+			continue
+		}
+		if output == nil {
+			output = make([]zapcore.Field, i, len(fields))
+			copy(output, fields[:i])
+		}
+		output = append(output, nf)
+	}
+	if output == nil {
+		return fields
+	}
+
+	return output
+}
@@ -7,9 +7,11 @@ package stackdriver
 import (
 	"runtime"
 	"strconv"
+	"sync"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
 )
 
 const (
@@ -55,7 +57,8 @@ func (sl SourceLocation) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	return nil
 }
 
-// LogSourceLocation adds the correct Stackdriver "SourceLocation" field.
+// LogSourceLocation adds the correct Stackdriver "SourceLocation" field. The source location is
+// delivered with the entry rather than encoded in the payload, see WithSourceLocationInPayload.
 func LogSourceLocation(pc uintptr, file string, line int, ok bool) zap.Field {
 	return zap.Object(sourceKey, NewSourceLocation(pc, file, line, ok))
 }
@@ -67,13 +70,100 @@ func NewSourceLocation(pc uintptr, file string, line int, ok bool) *SourceLocati
 		return nil
 	}
 
-	sl := &SourceLocation{
-		File: file,
-		Line: strconv.Itoa(line),
-	}
+	sl := &SourceLocation{}
+	sl.set(pc, file, line)
+
+	return sl
+}
+
+func (sl *SourceLocation) set(pc uintptr, file string, line int) {
+	sl.File = file
+	sl.Line = strconv.Itoa(line)
 	if fn := runtime.FuncForPC(pc); fn != nil {
 		sl.Function = fn.Name()
 	}
+}
+
+// proto returns sl as the source location of the sdlogging.Entry.
+func (sl *SourceLocation) proto() *logpb.LogEntrySourceLocation {
+	line, _ := strconv.ParseInt(sl.Line, 10, 64)
+
+	return &logpb.LogEntrySourceLocation{
+		File:     sl.File,
+		Line:     line,
+		Function: sl.Function,
+	}
+}
+
+// WithSourceLocationInPayload additionally encodes the source location of the entry as the
+// "logging.googleapis.com/sourceLocation" field of the payload, as the Encoder did before the
+// source location was delivered with the entry, for the queries which rely on the field.
+func WithSourceLocationInPayload(enable bool) Option {
+	return func(o *options) {
+		o.sourceLocationInPayload = enable
+	}
+}
+
+// extractSourceLocation removes the source location fields, and returns the last source
+// location found.
+func extractSourceLocation(fields []zapcore.Field) ([]zapcore.Field, *SourceLocation) {
+	var (
+		output []zapcore.Field
+		sl     *SourceLocation
+	)
+	for i, f := range fields {
+		loc, ok := asSourceLocation(f)
+		if !ok {
+			if output != nil {
+				output = append(output, f)
+			}
+			continue
+		}
+		if output == nil {
+			output = make([]zapcore.Field, i, len(fields))
+			copy(output, fields[:i])
+		}
+		sl = loc
+	}
+	if output == nil {
+		return fields, nil
+	}
+
+	return output, sl
+}
+
+func asSourceLocation(f zapcore.Field) (*SourceLocation, bool) {
+	if f.Key != sourceKey || f.Type != zapcore.ObjectMarshalerType {
+		return nil, false
+	}
+	switch sl := f.Interface.(type) {
+	case *SourceLocation:
+		return sl, sl != nil
+	case SourceLocation:
+		return &sl, true
+	}
+
+	return nil, false
+}
+
+// sourceLocationPool pools the SourceLocations of the entries, which are only referenced until
+// the entry is encoded.
+var sourceLocationPool = sync.Pool{
+	New: func() interface{} {
+		return new(SourceLocation)
+	},
+}
+
+// acquireSourceLocation returns a SourceLocation from the pool, set to pc, file and line.
+func acquireSourceLocation(pc uintptr, file string, line int) *SourceLocation {
+	sl := sourceLocationPool.Get().(*SourceLocation)
+	sl.set(pc, file, line)
 
 	return sl
 }
+
+// releaseSourceLocation returns sl to the pool.
+func releaseSourceLocation(sl *SourceLocation) {
+	*sl = SourceLocation{}
+	sourceLocationPool.Put(sl)
+}
@@ -0,0 +1,235 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/zchee/zap-encoder/internal/testutil"
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+func TestEncoderCaller(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig())
+
+	ent := zapcore.Entry{
+		Level:   zapcore.InfoLevel,
+		Message: "msg",
+		Caller:  zapcore.NewEntryCaller(0, "/go/src/pkg/file.go", 42, true),
+	}
+	buf, err := enc.EncodeEntry(ent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Free()
+
+	if n := strings.Count(buf.String(), `"caller"`); n != 1 {
+		t.Errorf("got %d caller keys, want 1: %s", n, buf.String())
+	}
+	if got, want := decodePayload(t, lg.Entries()[0])["caller"], "pkg/file.go:42"; got != want {
+		t.Errorf("got caller %#v, want %#v", got, want)
+	}
+}
+
+func TestEncoderWithSourceLocation(t *testing.T) {
+	pc, file, line, _ := runtime.Caller(0)
+	caller := zapcore.NewEntryCaller(pc, file, line, true)
+
+	tests := []struct {
+		name   string
+		enable bool
+		level  zapcore.Level
+		want   bool
+	}{
+		{name: "error entry", enable: true, level: zapcore.ErrorLevel, want: true},
+		{name: "info entry", enable: true, level: zapcore.InfoLevel, want: false},
+		{name: "disabled", enable: false, level: zapcore.ErrorLevel, want: false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			lg := testutil.NewFakeLogger()
+			enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+				stackdriver.WithSourceLocation(tt.enable),
+			)
+			buf, err := enc.EncodeEntry(zapcore.Entry{Level: tt.level, Message: "msg", Caller: caller}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer buf.Free()
+
+			sl := lg.Entries()[0].SourceLocation
+			if ok := sl != nil; ok != tt.want {
+				t.Fatalf("got sourceLocation %t, want %t", ok, tt.want)
+			}
+			if !tt.want {
+				return
+			}
+			if sl.File != file || sl.Line != int64(line) {
+				t.Errorf("got %s:%d, want %s:%d", sl.File, sl.Line, file, line)
+			}
+			if !strings.HasSuffix(sl.Function, "TestEncoderWithSourceLocation") {
+				t.Errorf("unexpected function: %v", sl.Function)
+			}
+		})
+	}
+}
+
+func TestEncoderWithAlwaysSourceLocation(t *testing.T) {
+	pc, file, line, _ := runtime.Caller(0)
+	caller := zapcore.NewEntryCaller(pc, file, line, true)
+
+	tests := []struct {
+		name   string
+		enable bool
+		level  zapcore.Level
+		want   bool
+	}{
+		{name: "info entry", enable: true, level: zapcore.InfoLevel, want: true},
+		{name: "debug entry", enable: true, level: zapcore.DebugLevel, want: true},
+		{name: "disabled", enable: false, level: zapcore.InfoLevel, want: false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			lg := testutil.NewFakeLogger()
+			enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+				stackdriver.WithAlwaysSourceLocation(tt.enable),
+			)
+			buf, err := enc.EncodeEntry(zapcore.Entry{Level: tt.level, Message: "msg", Caller: caller}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer buf.Free()
+
+			sl := lg.Entries()[0].SourceLocation
+			if ok := sl != nil; ok != tt.want {
+				t.Fatalf("got sourceLocation %t, want %t", ok, tt.want)
+			}
+			if sl.GetFile() != "" && sl.GetFile() != file {
+				t.Errorf("got file %v, want %s", sl.GetFile(), file)
+			}
+		})
+	}
+}
+
+func TestEncoderWithSourceFileTrim(t *testing.T) {
+	const file = "/home/runner/go/src/github.com/zchee/zap-encoder/stackdriver/file.go"
+
+	tests := []struct {
+		name   string
+		prefix string
+		want   string
+	}{
+		{name: "module root", prefix: "/home/runner/go/src/github.com/zchee/zap-encoder/", want: "stackdriver/file.go"},
+		{name: "without trailing slash", prefix: "/home/runner/go/src/github.com/zchee/zap-encoder", want: "stackdriver/file.go"},
+		{name: "unmatched prefix", prefix: "/build/", want: file},
+		{name: "partial directory name", prefix: "/home/runner/go/src/github.com/zchee/zap", want: file},
+		{name: "disabled", prefix: "", want: file},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			lg := testutil.NewFakeLogger()
+			enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+				stackdriver.WithSourceLocation(true),
+				stackdriver.WithSourceFileTrim(tt.prefix),
+			)
+			enc.(*stackdriver.Encoder).SetReportLocation = true
+
+			ent := zapcore.Entry{
+				Level:   zapcore.ErrorLevel,
+				Message: "msg",
+				Caller:  zapcore.NewEntryCaller(0, file, 42, true),
+			}
+			buf, err := enc.EncodeEntry(ent, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer buf.Free()
+
+			if got := lg.Entries()[0].SourceLocation.GetFile(); got != tt.want {
+				t.Errorf("got sourceLocation file %v, want %s", got, tt.want)
+			}
+			ctx, _ := decodePayload(t, lg.Entries()[0])["context"].(map[string]interface{})
+			rl, _ := ctx["reportLocation"].(map[string]interface{})
+			if got := rl["filePath"]; got != tt.want {
+				t.Errorf("got reportLocation filePath %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncoderExplicitSourceLocation(t *testing.T) {
+	pc, file, line, _ := runtime.Caller(0)
+	caller := zapcore.NewEntryCaller(pc, file, line, true)
+
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+		stackdriver.WithAlwaysSourceLocation(true),
+	)
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Message: "msg", Caller: caller}
+	for _, fields := range [][]zapcore.Field{
+		{stackdriver.LogSourceLocation(0, "pkg/file.go", 42, true)},
+		nil,
+	} {
+		buf, err := enc.EncodeEntry(ent, fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Free()
+	}
+
+	entries := lg.Entries()
+	if sl := entries[0].SourceLocation; sl.GetFile() != "pkg/file.go" || sl.GetLine() != 42 {
+		t.Errorf("got sourceLocation %v, want the explicit pkg/file.go:42", sl)
+	}
+	if sl := entries[1].SourceLocation; sl.GetFile() != file || sl.GetLine() != int64(line) {
+		t.Errorf("got sourceLocation %v, want the caller %s:%d", sl, file, line)
+	}
+}
+
+func TestEncoderSourceLocationInPayload(t *testing.T) {
+	field := stackdriver.LogSourceLocation(0, "pkg/file.go", 42, true)
+
+	tests := []struct {
+		name    string
+		opts    []stackdriver.Option
+		payload bool
+	}{
+		{name: "entry"},
+		{name: "payload", opts: []stackdriver.Option{stackdriver.WithSourceLocationInPayload(true)}, payload: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			lg := testutil.NewFakeLogger()
+			logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel, tt.opts...)
+			logger.Info("msg", field)
+			logger.With(field).Info("msg")
+
+			for i, ent := range lg.Entries() {
+				if sl := ent.SourceLocation; sl.GetFile() != "pkg/file.go" || sl.GetLine() != 42 {
+					t.Errorf("#%d: got sourceLocation %v, want pkg/file.go:42", i, sl)
+				}
+				sl, ok := decodePayload(t, ent)["logging.googleapis.com/sourceLocation"].(map[string]interface{})
+				if ok != tt.payload {
+					t.Fatalf("#%d: got sourceLocation in the payload %t, want %t", i, ok, tt.payload)
+				}
+				if ok && (sl["file"] != "pkg/file.go" || sl["line"] != "42") {
+					t.Errorf("#%d: got payload sourceLocation %v", i, sl)
+				}
+			}
+		})
+	}
+}
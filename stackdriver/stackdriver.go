@@ -5,47 +5,151 @@
 package stackdriver
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"net/url"
 	"runtime"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	sdlogging "cloud.google.com/go/logging"
 	"go.opencensus.io/trace"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"go.uber.org/zap/buffer"
 	"go.uber.org/zap/zapcore"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
 )
 
 func init() {
 	if err := zap.RegisterEncoder("stackdriver", func(cfg zapcore.EncoderConfig) (zapcore.Encoder, error) {
-		return &Encoder{
-			Encoder: zapcore.NewJSONEncoder(cfg),
-		}, nil
+		return NewStackdriverEncoder(context.Background(), nil, cfg), nil
 	}); err != nil {
 		panic(err)
 	}
 }
 
+// Logger represents a Stackdriver logger which the Encoder delivers entries to.
+//
+// *sdlogging.Logger implements Logger.
+type Logger interface {
+	Log(e sdlogging.Entry)
+	Flush() error
+}
+
+//pragma: compiler time checks whether the sdlogging.Logger implemented Logger interface.
+var _ Logger = (*sdlogging.Logger)(nil)
+
 // Encoder represents a zap.Encoder with stackdriver logging.
 type Encoder struct {
-	lg                *sdlogging.Logger
+	lg                Logger
 	SetReportLocation bool
 	ctx               *LogContext
+	opts              *options
+	trace             string
+	insertID          string
+	resource          *mrpb.MonitoredResource
+	httpRequest       *sdlogging.HTTPRequest
+	operation         *Operation
+	sourceLocation    *SourceLocation
+	labels            map[string]string
+	fields            []zapcore.Field // held by WithDedupeFields
+	namespaced        bool            // the fields of With are in a namespace
+	droppedNamespace  bool            // the namespace of With is dropped by WithFieldAllowlist
+
+	// SeverityMapper maps the levels to the Stackdriver severity in place of the default
+	// mapping, e.g. DPanicLevel to sdlogging.Error rather than sdlogging.Critical, or a custom
+	// level to a chosen severity. The severity is used for both the entry delivered to
+	// Stackdriver and the "severity" field of the payload. The levels in WithSeverityMap keep
+	// their severity.
+	SeverityMapper func(zapcore.Level) sdlogging.Severity
+
+	// GenerateInsertID derives the insert ID of the entries without one from the hash of their
+	// timestamp, level and message, so the entries written twice by a retry are deduplicated
+	// by Stackdriver. However, the distinct entries which share them, e.g. logged in a loop
+	// within the timestamp precision, are deduplicated too and only one of them is kept.
+	GenerateInsertID bool
 
 	zapcore.Encoder
 	*zapcore.EncoderConfig
 }
 
 // NewDefaultStackdriverClient returns the stackdriver logging client with default options.
-func NewDefaultStackdriverClient(ctx context.Context, projectID, logID string) *sdlogging.Logger {
-	sd, err := sdlogging.NewClient(ctx, projectID)
+//
+// It panics if the logging client can't be created, see NewStackdriverClient.
+func NewDefaultStackdriverClient(ctx context.Context, projectID, logID string, opts ...ClientOption) *sdlogging.Logger {
+	lg, err := NewStackdriverClient(ctx, projectID, logID, opts...)
+	if err != nil {
+		panic(err)
+	}
+
+	return lg
+}
+
+// NewStackdriverClient returns the stackdriver logging client with default options,
+// or an error if the logging client can't be created.
+//
+// The monitored resource of the entries is detected by DetectResource, unless it's set by
+// WithCommonResource.
+func NewStackdriverClient(ctx context.Context, projectID, logID string, opts ...ClientOption) (*sdlogging.Logger, error) {
+	o := new(clientOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	parent, logID := resolveLogName(projectID, logID, o)
+	sd, err := sdlogging.NewClient(ctx, parent)
 	if err != nil {
-		panic(fmt.Errorf("failed to create logging client: %+v", err))
+		return nil, fmt.Errorf("failed to create logging client: %+v", err)
 	}
 	sd.OnError = func(error) {}
+	if o.onError != nil {
+		sd.OnError = o.onError
+	}
 
-	ctxFn := func() (context.Context, func()) {
+	lgOpts := []sdlogging.LoggerOption{sdlogging.ContextFunc(newContextFunc(ctx, o))}
+	resource := o.resource
+	if resource == nil {
+		resource = DetectResource(ctx)
+	}
+	if resource != nil {
+		lgOpts = append(lgOpts, sdlogging.CommonResource(resource))
+	}
+
+	return sd.Logger(logID, lgOpts...), nil
+}
+
+// resolveLogName returns the parent of the logging client and the log ID, which are scoped to
+// the project of the WithLogName if it's set.
+func resolveLogName(projectID, logID string, o *clientOptions) (string, string) {
+	if !strings.HasPrefix(o.logName, "projects/") {
+		return projectID, logID
+	}
+	i := strings.Index(o.logName, "/logs/")
+	if i < 0 || i+len("/logs/") == len(o.logName) {
+		return projectID, logID
+	}
+	id, err := url.PathUnescape(o.logName[i+len("/logs/"):])
+	if err != nil {
+		return projectID, logID
+	}
+
+	return o.logName[:i], id
+}
+
+// newContextFunc returns the function which supplies the context of the logging client calls.
+func newContextFunc(ctx context.Context, o *clientOptions) func() (context.Context, func()) {
+	if o.noThrowawaySpan {
+		return func() (context.Context, func()) {
+			return context.WithTimeout(context.Background(), 5*time.Second)
+		}
+	}
+
+	return func() (context.Context, func()) {
 		ctx, span := trace.StartSpan(ctx, "this span will not be exported", trace.WithSampler(trace.NeverSample()))
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		afterCallFn := func() {
@@ -54,26 +158,115 @@ func NewDefaultStackdriverClient(ctx context.Context, projectID, logID string) *
 		}
 		return ctx, afterCallFn
 	}
-
-	return sd.Logger(logID, sdlogging.ContextFunc(ctxFn))
 }
 
 // NewLogger returns the new zap.Logger with stackdriver zapcore.Encoder.
-func NewLogger(ctx context.Context, lg *sdlogging.Logger, lv zapcore.Level) *zap.Logger {
-	enc := NewStackdriverEncoder(ctx, lg, NewStackdriverEncoderConfig())
-	ws := &WriteSyncer{lg: lg}
+func NewLogger(ctx context.Context, lg Logger, lv zapcore.Level, opts ...Option) *zap.Logger {
+	enc := NewStackdriverEncoder(ctx, lg, NewStackdriverEncoderConfig(), opts...)
+	o := enc.(*Encoder).opts
+	ws := &WriteSyncer{lg: enc.(*Encoder).lg, loggers: o.projectLoggers, timeout: o.syncTimeout}
 	core := zapcore.NewCore(enc, ws, lv)
 
 	return zap.New(core)
 }
 
 // NewStackdriverEncoder returns the stackdriver zapcore.Encoder.
-func NewStackdriverEncoder(ctx context.Context, lg *sdlogging.Logger, encoderConfig zapcore.EncoderConfig) zapcore.Encoder {
-	return &Encoder{
-		lg:            lg,
-		Encoder:       zapcore.NewJSONEncoder(encoderConfig),
-		EncoderConfig: &encoderConfig,
+func NewStackdriverEncoder(ctx context.Context, lg Logger, encoderConfig zapcore.EncoderConfig, opts ...Option) zapcore.Encoder {
+	o := &options{
+		labelLimits: defaultLabelLimits,
 	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if l, ok := lg.(*sdlogging.Logger); ok && l == nil {
+		lg = nil
+	}
+	if o.fallback != nil {
+		lg = newFallbackLogger(lg, o.fallback)
+	}
+	if o.projectIDResolver != nil {
+		o.projectLoggers = newLoggerCache(o.newProjectLogger)
+	}
+	if o.severityFloor == nil {
+		floor := int32(noSeverityFloor)
+		o.severityFloor = &floor
+	}
+	if o.durationEncoder != nil {
+		encoderConfig.EncodeDuration = o.durationEncoder
+	}
+	o.stats = new(encoderStats)
+
+	if encoderConfig.LevelKey != "" {
+		key, _ := json.Marshal(encoderConfig.LevelKey)
+		o.levelKey = string(key) + ":"
+		if encoderConfig.EncodeLevel != nil {
+			o.levelEncoder = zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+				LevelKey:    encoderConfig.LevelKey,
+				EncodeLevel: encoderConfig.EncodeLevel,
+				LineEnding:  zapcore.DefaultLineEnding,
+			})
+		}
+	}
+
+	e := &Encoder{
+		lg:   lg,
+		ctx:  logContextFrom(ctx, o.logContextKey),
+		opts: o,
+	}
+	// the level is written by EncodeEntry rather than by the zap encoder, whose EncoderConfig
+	// is shared by its clones, so it follows the SeverityMapper of the encoder at hand.
+	jsonConfig := encoderConfig
+	jsonConfig.LevelKey = ""
+	e.Encoder = zapcore.NewJSONEncoder(jsonConfig)
+	e.EncoderConfig = &encoderConfig
+
+	return e
+}
+
+// NewStackdriverEncoderFromConfig returns the stackdriver zapcore.Encoder configured by cfg.
+//
+// The cfg.EncoderConfig keys and encoders are honored, and any empty key or nil encoder is
+// filled from NewStackdriverEncoderConfig. The level key and level encoder are always replaced
+// with the stackdriver severity, because Stackdriver can't recognize any other.
+func NewStackdriverEncoderFromConfig(ctx context.Context, lg Logger, cfg zap.Config, opts ...Option) zapcore.Encoder {
+	return NewStackdriverEncoder(ctx, lg, mergeEncoderConfig(cfg.EncoderConfig), opts...)
+}
+
+func mergeEncoderConfig(ec zapcore.EncoderConfig) zapcore.EncoderConfig {
+	def := NewStackdriverEncoderConfig()
+
+	if ec.TimeKey == "" {
+		ec.TimeKey = def.TimeKey
+	}
+	if ec.NameKey == "" {
+		ec.NameKey = def.NameKey
+	}
+	if ec.CallerKey == "" {
+		ec.CallerKey = def.CallerKey
+	}
+	if ec.MessageKey == "" {
+		ec.MessageKey = def.MessageKey
+	}
+	if ec.StacktraceKey == "" {
+		ec.StacktraceKey = def.StacktraceKey
+	}
+	if ec.LineEnding == "" {
+		ec.LineEnding = def.LineEnding
+	}
+	if ec.EncodeTime == nil {
+		ec.EncodeTime = def.EncodeTime
+	}
+	if ec.EncodeDuration == nil {
+		ec.EncodeDuration = def.EncodeDuration
+	}
+	if ec.EncodeCaller == nil {
+		ec.EncodeCaller = def.EncodeCaller
+	}
+	ec.LevelKey = def.LevelKey
+	ec.EncodeLevel = def.EncodeLevel
+
+	return ec
 }
 
 // NewStackdriverConfig returns the stackdriver encoder zap.Config.
@@ -92,18 +285,67 @@ func NewStackdriverConfig() zap.Config {
 	}
 }
 
-var logLevelSeverity = map[zapcore.Level]string{
-	zapcore.DebugLevel:  "DEBUG",
-	zapcore.InfoLevel:   "INFO",
-	zapcore.WarnLevel:   "WARNING",
-	zapcore.ErrorLevel:  "ERROR",
-	zapcore.DPanicLevel: "CRITICAL",
-	zapcore.PanicLevel:  "ALERT",
-	zapcore.FatalLevel:  "EMERGENCY",
+// ValidateConfig reports whether the encoder of cfg is registered and can be built from
+// cfg.EncoderConfig, so the misconfiguration fails with a clear error rather than at
+// zap.Config.Build. The "stackdriver" encoder is registered by importing this package.
+func ValidateConfig(cfg zap.Config) error {
+	// build the encoder without opening the outputs.
+	probe := zap.Config{
+		Level:         zap.NewAtomicLevel(),
+		Encoding:      cfg.Encoding,
+		EncoderConfig: cfg.EncoderConfig,
+	}
+	if _, err := probe.Build(); err != nil {
+		return fmt.Errorf("stackdriver: invalid config: encoding %q: %v", cfg.Encoding, err)
+	}
+
+	return nil
 }
 
+// LevelEncoder encodes the level as the name of its default Stackdriver severity, e.g.
+// "WARNING". The Encoder writes the severity set by its SeverityMapper, WithSeverityMap or
+// WithDefaultSeverity instead, and only encodes the levels of the default mapping by the
+// EncodeLevel of its EncoderConfig.
 func LevelEncoder(l zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
-	enc.AppendString(logLevelSeverity[l])
+	enc.AppendString(severityName(parseLevel(l, nil)))
+}
+
+// appendLevel appends the level key and the severity of l to buf, so the "severity" field of
+// the payload agrees with the severity delivered to Stackdriver, see severity.
+func (e *Encoder) appendLevel(buf *buffer.Buffer, l zapcore.Level) {
+	if _, ok := e.opts.severityMap[l]; !ok && e.SeverityMapper == nil && e.opts.levelEncoder != nil && parseLevel(l, nil) != sdlogging.Default {
+		if lv, err := e.opts.levelEncoder.EncodeEntry(zapcore.Entry{Level: l}, nil); err == nil {
+			// lv holds {"<key>":<level>} followed by the line ending.
+			b := bytes.TrimSuffix(lv.Bytes(), []byte(zapcore.DefaultLineEnding))
+			buf.Write(b[1 : len(b)-1])
+			lv.Free()
+			return
+		}
+	}
+	buf.AppendString(e.opts.levelKey)
+	buf.AppendByte('"')
+	buf.AppendString(severityName(e.severity(l)))
+	buf.AppendByte('"')
+}
+
+// withLevel returns the encoded entry buf with the level added as its first key, as the zap
+// encoder writes it. buf is freed.
+func (e *Encoder) withLevel(buf *buffer.Buffer, l zapcore.Level) *buffer.Buffer {
+	out := bufferPool.Get()
+	out.AppendByte('{')
+	e.appendLevel(out, l)
+	if rest := buf.Bytes()[1:]; len(rest) > 0 && rest[0] != '}' {
+		out.AppendByte(',')
+	}
+	out.Write(buf.Bytes()[1:])
+	buf.Free()
+
+	return out
+}
+
+// severityName returns the upper-cased name of sev, e.g. "WARNING".
+func severityName(sev sdlogging.Severity) string {
+	return strings.ToUpper(sev.String())
 }
 
 // NewStackdriverEncoderConfig returns the new zapcore.EncoderConfig with stackdriver encoder config.
@@ -135,7 +377,20 @@ func (e *Encoder) Clone() zapcore.Encoder {
 	return &Encoder{
 		lg:                e.lg,
 		SetReportLocation: e.SetReportLocation,
+		GenerateInsertID:  e.GenerateInsertID,
+		SeverityMapper:    e.SeverityMapper,
 		ctx:               e.ctx,
+		opts:              e.opts,
+		trace:             e.trace,
+		insertID:          e.insertID,
+		resource:          e.resource,
+		httpRequest:       e.httpRequest,
+		operation:         e.operation,
+		sourceLocation:    e.sourceLocation,
+		labels:            e.labels,
+		fields:            e.fields[:len(e.fields):len(e.fields)],
+		namespaced:        e.namespaced,
+		droppedNamespace:  e.droppedNamespace,
 		Encoder:           e.Encoder.Clone(),
 		EncoderConfig:     e.EncoderConfig,
 	}
@@ -149,7 +404,13 @@ func (e *Encoder) cloneCtx() *LogContext {
 	return e.ctx.Clone()
 }
 
-func parseLevel(l zapcore.Level) (sev sdlogging.Severity) {
+// parseLevel returns the Stackdriver severity of l by mapper, or by the default mapping if mapper
+// is nil.
+func parseLevel(l zapcore.Level, mapper func(zapcore.Level) sdlogging.Severity) (sev sdlogging.Severity) {
+	if mapper != nil {
+		return mapper(l)
+	}
+
 	switch l {
 	case zapcore.DebugLevel:
 		sev = sdlogging.Debug
@@ -172,83 +433,498 @@ func parseLevel(l zapcore.Level) (sev sdlogging.Severity) {
 	return sev
 }
 
-func (e *Encoder) parseEntry(enc zapcore.Encoder, ent zapcore.Entry, cfg *zapcore.EncoderConfig) {
-	if cfg != nil {
-		if !ent.Time.IsZero() && cfg.TimeKey != "" {
-			enc.AddTime(cfg.TimeKey, ent.Time)
-		}
-		if ent.LoggerName != "" && cfg.NameKey != "" {
-			enc.AddString(cfg.NameKey, ent.LoggerName)
-		}
-		if ent.Caller.Defined && cfg.CallerKey != "" {
-			enc.AddReflected(cfg.CallerKey, ent.Caller)
-		}
-		if ent.Message != "" && cfg.MessageKey != "" {
-			enc.AddString(cfg.MessageKey, ent.Message)
-		}
-		if ent.Stack != "" && cfg.StacktraceKey != "" {
-			enc.AddString(cfg.StacktraceKey, ent.Stack)
-		}
+// severity returns the Stackdriver severity of l, which is overridden by WithSeverityMap and
+// SeverityMapper, or the severity set by WithDefaultSeverity if l has none.
+func (e *Encoder) severity(l zapcore.Level) sdlogging.Severity {
+	if sev, ok := e.opts.severityMap[l]; ok {
+		return sev
 	}
+	if sev := parseLevel(l, e.SeverityMapper); sev != sdlogging.Default {
+		return sev
+	}
+
+	return e.opts.defaultSeverity
 }
 
+// bufferPool provides the empty buffers returned for the filtered out entries.
+var bufferPool = buffer.NewPool()
+
 func (e *Encoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	if e.opts.entryFilter != nil && !e.opts.entryFilter(ent, fields) {
+		return bufferPool.Get(), nil
+	}
+
+	if ent.Time.IsZero() {
+		ent.Time = e.now()
+	}
+	if e.opts.timestampPrecision > 0 {
+		ent.Time = ent.Time.Round(e.opts.timestampPrecision)
+	}
+
 	enc := e.Encoder.Clone()
+	orig := fields
 
-	e.parseEntry(enc, ent, e.EncoderConfig)
+	fields = dropSkipFields(fields)
+	fields = e.transformKeys(fields)
+	fields = e.allowFields(fields)
+	if e.opts.dedupe != 0 {
+		fields = dedupeFields(append(e.fields[:len(e.fields):len(e.fields)], fields...), e.opts.dedupe)
+	}
+
+	if e.opts.messageTemplate != nil {
+		if msg, ok := e.opts.messageTemplate.render(fields); ok {
+			ent.Message = msg
+		}
+	}
+
+	if e.opts.maxFieldValueLength > 0 {
+		fields = truncateFields(fields, e.opts.maxFieldValueLength)
+	}
+
+	// the locations are only referenced until the entry is encoded, so they are pooled.
+	if e.SetReportLocation && ent.Caller.Defined {
+		rl := acquireReportLocation()
+		defer releaseReportLocation(rl)
+		e.setReportLocation(rl, ent.Caller)
+		fields = append(fields, WithReportLocation(rl))
+	}
+
+	trace, insertID := e.trace, e.insertID
+	fields, tc := expandTraceContext(fields)
+	if tc != nil {
+		trace = tc.Trace
+		if tc.InsertID != "" {
+			insertID = tc.InsertID
+		}
+	}
+	if id, ok := lastInsertID(fields); ok {
+		insertID = id
+	}
+	if insertID == "" && e.GenerateInsertID {
+		insertID = generateInsertID(ent)
+	}
+
+	resource := e.resource
+	fields, r := extractMonitoredResource(fields)
+	if r != nil {
+		resource = r
+	}
+
+	labels := e.labels
+	fields, m := e.extractLabels(fields)
+	if m != nil {
+		labels = mergeLabels(labels, m)
+	}
+
+	httpRequest := e.httpRequest
+	fields, req := extractNativeHTTPRequest(fields)
+	if req != nil {
+		httpRequest = req
+	}
+
+	fields, op := extractOperation(fields)
+	op = e.operation.merge(op)
+	if op != nil {
+		fields = append(fields, zap.Object(keyOperation, op))
+	}
 
 	fields, ctx := e.extractCtx(fields)
 	if ctx != nil {
 		fields = append(fields, WithContext(ctx))
 	}
 
-	rl := e.ReportLocationFromEntry(ent, fields)
-	if rl != nil {
-		fields = append(fields, WithReportLocation(rl))
+	sourceLocation := e.sourceLocation
+	fields, sl := extractSourceLocation(fields)
+	if sl != nil {
+		sourceLocation = sl
+	}
+	// the source location passed with the entry wins over the one of the caller.
+	if sl == nil && e.hasSourceLocation(ent) {
+		caller := acquireSourceLocation(ent.Caller.PC, e.trimSourceFile(ent.Caller.File), ent.Caller.Line)
+		defer releaseSourceLocation(caller)
+		sourceLocation = caller
+	}
+	if sourceLocation != nil && e.opts.sourceLocationInPayload {
+		fields = append(fields, zap.Object(sourceKey, sourceLocation))
 	}
 
+	if e.opts.timestampAlias != "" {
+		fields = append(fields, zap.Time(e.opts.timestampAlias, ent.Time))
+	}
+	if e.opts.receiveTimestamp {
+		fields = append(fields, zap.Time(keyReceiveTimestamp, e.now()))
+	}
+	if e.opts.structuredStack && ent.Stack != "" && e.StacktraceKey != "" {
+		if frames, ok := parseStacktrace(ent.Stack); ok {
+			fields = append(fields, zap.Array(e.StacktraceKey, frames))
+			ent.Stack = ""
+		}
+	}
+
+	fields = marshalInline(enc, fields)
+	fields = nativeSliceFields(fields)
+	if e.opts.noHTMLEscape || e.opts.newReflectedEncoder != nil {
+		fields = replayReflectedFields(fields, e.opts.newReflectedEncoder)
+	}
+	if e.opts.maxObjectDepth > 0 {
+		fields = limitFieldsDepth(fields, e.opts.maxObjectDepth)
+	}
+	fields = safeObjectFields(fields)
+
 	buf, err := enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return nil, err
+	}
+	if e.opts.levelKey != "" {
+		buf = e.withLevel(buf, ent.Level)
+	}
+	atomic.AddUint64(&e.opts.stats.encoded, 1)
+
+	if !e.deliverable(ent) {
+		return buf, nil
+	}
+
+	payload := e.trimLineEnding(buf.String())
+	if len(payload) > MaxPayloadSize {
+		// the entry is still written by zap, which drops the write of an entry failed to encode.
+		e.reportError(&PayloadTooLargeError{Size: len(payload), Limit: MaxPayloadSize})
+		return buf, nil
+	}
+
 	entry := sdlogging.Entry{
 		Timestamp: ent.Time,
-		Severity:  parseLevel(ent.Level),
-		Payload:   buf.String(),
+		Severity:  e.severity(ent.Level),
+		Payload:   payload,
+		Labels:    e.entryLabels(ent, labels),
+		Trace:     trace,
+		Resource:  resource,
+		InsertID:  insertID,
+	}
+	entry.HTTPRequest = httpRequest
+	if op != nil {
+		entry.Operation = op.proto()
+	}
+	if sourceLocation != nil {
+		entry.SourceLocation = sourceLocation.proto()
+	}
+	// the entry is delivered to the default Logger if the Logger of its project is
+	// unavailable, and the encoded entry is still written by zap.
+	lg, err := e.logger(ent, orig)
+	if err != nil {
+		e.reportError(err)
+	}
+	if lg == nil {
+		return buf, nil
+	}
+	lg.Log(entry)
+	atomic.AddUint64(&e.opts.stats.delivered, 1)
+
+	// zap may panic or exit the process right after writing the entry at DPanicLevel,
+	// PanicLevel and FatalLevel, so deliver it synchronously instead of leaving it to the
+	// background bundler. The custom levels above FatalLevel don't stop the process, so they
+	// aren't flushed. The flush error is only reported, for zap to still write the entry
+	// before the process dies.
+	if ent.Level == zapcore.PanicLevel || ent.Level == zapcore.DPanicLevel || ent.Level == zapcore.FatalLevel {
+		if err := lg.Flush(); err != nil {
+			e.reportError(err)
+		}
 	}
-	e.lg.Log(entry)
 
-	return buf, err
+	return buf, nil
+}
+
+// reportError counts err as the delivery error, and passes it to the WithErrorHandler handler.
+func (e *Encoder) reportError(err error) {
+	atomic.AddUint64(&e.opts.stats.errors, 1)
+	if e.opts.errorHandler != nil {
+		e.opts.errorHandler(err)
+	}
+}
+
+// EntryWithFields is an entry and its fields to encode by EncodeEntries.
+type EntryWithFields struct {
+	Entry  zapcore.Entry
+	Fields []zapcore.Field
+}
+
+// EncodeEntries encodes and delivers the entries in order, e.g. the archived logs replayed by the
+// bulk import tools. The sdlogging.Logger has no batch call, its bundler batches the entries in
+// the background, so Sync the WriteSyncer to wait for the delivery.
+//
+// It returns the buffers of the encoded entries in the order of entries, and the first error.
+// The buffer of an entry which failed to encode is nil.
+func (e *Encoder) EncodeEntries(entries []EntryWithFields) ([]*buffer.Buffer, error) {
+	var firstErr error
+	bufs := make([]*buffer.Buffer, len(entries))
+	for i, ent := range entries {
+		buf, err := e.EncodeEntry(ent.Entry, ent.Fields)
+		bufs[i] = buf
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return bufs, firstErr
+}
+
+// now returns the current time of the clock set by WithClock.
+func (e *Encoder) now() time.Time {
+	if e.opts.clock != nil {
+		return e.opts.clock()
+	}
+
+	return timeNow()
+}
+
+// trimLineEnding trims the line ending, which zap appends to the encoded entry for the
+// line oriented outputs, from the payload.
+func (e *Encoder) trimLineEnding(payload string) string {
+	lineEnding := zapcore.DefaultLineEnding
+	if e.EncoderConfig != nil && e.EncoderConfig.LineEnding != "" {
+		lineEnding = e.EncoderConfig.LineEnding
+	}
+
+	return strings.TrimSuffix(payload, lineEnding)
+}
+
+// noSeverityFloor is the severity floor until WithSeverityFloor or SetSeverityFloor sets it,
+// which delivers the entries of every level, including the custom levels below DebugLevel.
+const noSeverityFloor = math.MinInt32
+
+// OnError counts err as a failed delivery toward the switch to the WithConsoleFallback writer,
+// like a failed flush. Pass it to the logging client error handler, e.g. by WithOnError, so the
+// entries fall back without waiting for Sync, which the bundle upload errors never reach. It
+// applies to e and every encoder cloned from it, and does nothing without WithConsoleFallback.
+func (e *Encoder) OnError(err error) {
+	if err == nil {
+		return
+	}
+	if l, ok := e.lg.(*fallbackLogger); ok {
+		l.reportFailure()
+	}
+}
+
+// SetSeverityFloor sets the minimum level delivered to Stackdriver. It's safe for concurrent
+// use, and applies to e and every encoder cloned from it, e.g. by the zap.Logger.With.
+// The entries below the floor are still encoded, but not delivered.
+func (e *Encoder) SetSeverityFloor(lv zapcore.Level) {
+	atomic.StoreInt32(e.opts.severityFloor, int32(lv))
+}
+
+// SeverityFloor returns the minimum level delivered to Stackdriver. It returns the lowest
+// zapcore.Level unless the floor is set, since every level is delivered.
+func (e *Encoder) SeverityFloor() zapcore.Level {
+	floor := atomic.LoadInt32(e.opts.severityFloor)
+	if floor == noSeverityFloor {
+		return zapcore.Level(math.MinInt8)
+	}
+
+	return zapcore.Level(floor)
+}
+
+// deliverable reports whether the entry is delivered to Stackdriver.
+func (e *Encoder) deliverable(ent zapcore.Entry) bool {
+	if e.opts.disabled {
+		return false
+	}
+	if floor := atomic.LoadInt32(e.opts.severityFloor); floor != noSeverityFloor && int32(ent.Level) < floor {
+		return false
+	}
+	if lv, ok := e.opts.loggerThresholds[ent.LoggerName]; ok && ent.Level < lv {
+		return false
+	}
+
+	return true
+}
+
+// logger returns the Logger which the entry is delivered to.
+//
+// If the project ID resolver picks a project whose logger can't be created, it
+// returns the default logger along with the error.
+func (e *Encoder) logger(ent zapcore.Entry, fields []zapcore.Field) (Logger, error) {
+	if e.opts.projectIDResolver == nil {
+		return e.lg, nil
+	}
+
+	projectID := e.opts.projectIDResolver(ent, fields)
+	if projectID == "" {
+		return e.lg, nil
+	}
+	lg, err := e.opts.projectLoggers.get(projectID)
+	if err != nil {
+		return e.lg, err
+	}
+
+	return lg, nil
+}
+
+const ellipsis = "…"
+
+// dropSkipFields removes the no-op fields, e.g. the zap.Skip of a conditional field, so the
+// special keys are never matched against them.
+func dropSkipFields(fields []zapcore.Field) []zapcore.Field {
+	var output []zapcore.Field
+	for i, f := range fields {
+		if f.Type != zapcore.SkipType {
+			if output != nil {
+				output = append(output, f)
+			}
+			continue
+		}
+		if output == nil {
+			output = make([]zapcore.Field, i, len(fields))
+			copy(output, fields[:i])
+		}
+	}
+	if output == nil {
+		return fields
+	}
+
+	return output
+}
+
+// truncateFields returns fields with every string, byte string and fmt.Stringer
+// value longer than n bytes cut to n bytes and suffixed with an ellipsis. The
+// fields slice is copied before modification so the caller's fields are left
+// untouched.
+func truncateFields(fields []zapcore.Field, n int) []zapcore.Field {
+	var output []zapcore.Field
+	for i, f := range fields {
+		f, ok := truncateField(f, n)
+		if !ok {
+			continue
+		}
+		if output == nil {
+			output = make([]zapcore.Field, len(fields))
+			copy(output, fields)
+		}
+		output[i] = f
+	}
+	if output == nil {
+		return fields
+	}
+
+	return output
+}
+
+// truncateField returns f with the value cut by truncateString, and whether the
+// value is longer than n bytes. The fmt.Stringer value is replaced with the
+// string field.
+func truncateField(f zapcore.Field, n int) (zapcore.Field, bool) {
+	switch f.Type {
+	case zapcore.StringType:
+		if len(f.String) > n {
+			f.String = truncateString(f.String, n)
+			return f, true
+		}
+	case zapcore.ByteStringType:
+		if b, ok := f.Interface.([]byte); ok && len(b) > n {
+			f.Interface = []byte(truncateString(string(b), n))
+			return f, true
+		}
+	case zapcore.StringerType:
+		if s, ok := f.Interface.(fmt.Stringer); ok {
+			if v := s.String(); len(v) > n {
+				return zap.String(f.Key, truncateString(v, n)), true
+			}
+		}
+	}
+
+	return f, false
+}
+
+// truncateString cuts s to at most n bytes without splitting a UTF-8 sequence
+// and appends an ellipsis.
+func truncateString(s string, n int) string {
+	return cutString(s, n) + ellipsis
 }
 
 const (
 	keyServiceContext        = "serviceContext"
+	keyReceiveTimestamp      = "receiveTimestamp"
 	keyContext               = "context"
 	keyContextHTTPRequest    = "context.httpRequest"
 	keyContextUser           = "context.user"
 	keyContextReportLocation = "context.reportLocation"
 )
 
+// extractCtx moves the context fields out of fields into the LogContext. It returns
+// the remaining fields, and the LogContext or nil if there is no context field.
 func (e *Encoder) extractCtx(fields []zapcore.Field) ([]zapcore.Field, *LogContext) {
-	output := []zapcore.Field{}
+	output := make([]zapcore.Field, 0, len(fields))
 	lc := e.cloneCtx()
-	if lc.IsEmpty() {
-		return fields, nil
+	prefix := e.opts.contextPrefix
+
+	// the LogContext of the request context is the base of the context fields, wherever it is.
+	for _, f := range fields {
+		if ctx, ok := asRequestContext(f); ok {
+			if rlc := logContextFrom(ctx, e.opts.logContextKey); rlc != nil {
+				lc = rlc
+			}
+		}
 	}
 
 	for _, f := range fields {
-		switch f.Key {
-		case keyContextHTTPRequest:
-			lc.HTTPRequest = f.Interface.(*HTTPRequest)
-		case keyContextReportLocation:
-			lc.ReportLocation = f.Interface.(*ReportLocation)
-		case keyContextUser:
-			lc.User = f.String
+		_, isRequestContext := asRequestContext(f)
+		switch {
+		case f.Type == zapcore.SkipType, isRequestContext:
+		case setContextField(lc, f):
+		case prefix != "" && len(f.Key) > len(prefix) && strings.HasPrefix(f.Key, prefix):
+			f.Key = f.Key[len(prefix):]
+			lc.Fields = append(lc.Fields, f)
 		default:
-			// output = append(output, f)
+			output = append(output, f)
 		}
 	}
+	if lc.IsEmpty() {
+		return output, nil
+	}
 
 	return output, lc
 }
 
+// setContextField sets f to lc if f is a known context field of the expected type, and
+// reports whether it was set. The fields of any other type, and the nil values, are left to
+// the JSON encoder.
+func setContextField(lc *LogContext, f zapcore.Field) bool {
+	switch f.Key {
+	case keyContextHTTPRequest:
+		switch req := f.Interface.(type) {
+		case *HTTPRequest:
+			if req != nil {
+				lc.HTTPRequest = req
+			}
+			return req != nil
+		case *HttpRequest:
+			if req != nil {
+				lc.HTTPRequest = httpRequestContext(req)
+			}
+			return req != nil
+		}
+		return false
+	case keyContextReportLocation:
+		loc, ok := f.Interface.(*ReportLocation)
+		if ok && loc != nil {
+			lc.ReportLocation = loc
+		}
+		return ok && loc != nil
+	case keyContextUser:
+		switch f.Type {
+		case zapcore.StringType:
+			lc.User = f.String
+			return true
+		case zapcore.StringerType:
+			user, ok := f.Interface.(fmt.Stringer)
+			if ok {
+				lc.User = user.String()
+			}
+			return ok
+		}
+	}
+
+	return false
+}
+
 func (e *Encoder) ReportLocationFromEntry(ent zapcore.Entry, fields []zapcore.Field) *ReportLocation {
 	if !e.SetReportLocation {
 		return nil
@@ -259,20 +935,53 @@ func (e *Encoder) ReportLocationFromEntry(ent zapcore.Entry, fields []zapcore.Fi
 		return nil
 	}
 
-	loc := &ReportLocation{
-		FilePath:   caller.File,
-		LineNumber: caller.Line,
-	}
+	loc := &ReportLocation{}
+	e.setReportLocation(loc, caller)
+
+	return loc
+}
+
+func (e *Encoder) setReportLocation(loc *ReportLocation, caller zapcore.EntryCaller) {
+	loc.FilePath = e.trimSourceFile(caller.File)
+	loc.LineNumber = caller.Line
 	if fn := runtime.FuncForPC(caller.PC); fn != nil {
 		loc.FunctionName = fn.Name()
 	}
+}
 
-	return loc
+// hasSourceLocation reports whether the entry has the structured source location field, see
+// WithSourceLocation and WithAlwaysSourceLocation.
+func (e *Encoder) hasSourceLocation(ent zapcore.Entry) bool {
+	if !ent.Caller.Defined {
+		return false
+	}
+
+	return e.opts.sourceLocationAll || e.opts.sourceLocation && ent.Level >= zapcore.ErrorLevel
+}
+
+// trimSourceFile trims the prefix set by WithSourceFileTrim from the file path.
+func (e *Encoder) trimSourceFile(file string) string {
+	prefix := e.opts.sourceFilePrefix
+	if prefix == "" || !strings.HasPrefix(file, prefix) {
+		return file
+	}
+
+	rest := file[len(prefix):]
+	if !strings.HasSuffix(prefix, "/") {
+		// the prefix must end at a path separator.
+		if !strings.HasPrefix(rest, "/") {
+			return file
+		}
+	}
+
+	return strings.TrimLeft(rest, "/")
 }
 
 // WriteSyncer represents a zapcore.WriteSyncer with stackdriver logging.
 type WriteSyncer struct {
-	lg *sdlogging.Logger
+	lg      Logger
+	loggers *loggerCache // see WithProjectIDResolver
+	timeout time.Duration
 }
 
 //pragma: compiler time checks whether the WriteSyncer implemented zapcore.WriteSyncer interface.
@@ -284,6 +993,40 @@ func (ws *WriteSyncer) Write(b []byte) (int, error) {
 }
 
 // Sync implements zapcore.WriteSyncer.
+//
+// It blocks until the entries buffered by the Logger and the per-project loggers are delivered,
+// and returns their flush errors combined. With WithSyncTimeout, it returns ErrSyncTimeout once
+// the timeout elapses, while the delivery carries on in the background.
 func (ws *WriteSyncer) Sync() error {
-	return ws.lg.Flush()
+	loggers := ws.loggers.all()
+	if ws.lg != nil {
+		loggers = append(loggers, ws.lg)
+	}
+	if ws.timeout <= 0 {
+		return flushLoggers(loggers)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- flushLoggers(loggers)
+	}()
+	timer := time.NewTimer(ws.timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return ErrSyncTimeout
+	}
+}
+
+// flushLoggers flushes each of loggers, and returns their errors combined.
+func flushLoggers(loggers []Logger) error {
+	var err error
+	for _, lg := range loggers {
+		err = multierr.Append(err, lg.Flush())
+	}
+
+	return err
 }
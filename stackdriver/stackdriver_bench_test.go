@@ -6,8 +6,10 @@ package stackdriver_test
 
 import (
 	"context"
+	"reflect"
 	"testing"
 
+	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
 	"github.com/zchee/zap-encoder/internal/testutil"
@@ -21,7 +23,7 @@ func BenchmarkStackdriverEncoderLogMarshalerFunc(b *testing.B) {
 	uids := uid.NewSpace(testLogIDPrefix, nil)
 	testLogID := uids.New()
 
-	lg := stackdriver.NewDefaultStackdriverClient(ctx, testProjectID, testLogID)
+	lg := newTestLogger(ctx, testProjectID, testLogID)
 	enc := stackdriver.NewStackdriverEncoder(ctx, lg, stackdriver.NewStackdriverEncoderConfig())
 	b.ResetTimer()
 
@@ -32,3 +34,111 @@ func BenchmarkStackdriverEncoderLogMarshalerFunc(b *testing.B) {
 		}))
 	}
 }
+
+func benchmarkEntries(n int) []stackdriver.EntryWithFields {
+	entries := make([]stackdriver.EntryWithFields, n)
+	for i := range entries {
+		entries[i] = stackdriver.EntryWithFields{
+			Entry:  zapcore.Entry{Level: zapcore.InfoLevel, Message: "replayed"},
+			Fields: []zapcore.Field{zap.Int("line", i)},
+		}
+	}
+	return entries
+}
+
+func BenchmarkEncodeEntry(b *testing.B) {
+	entries := benchmarkEntries(100)
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), testutil.NewFakeLogger(), stackdriver.NewStackdriverEncoderConfig())
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, ent := range entries {
+			buf, err := enc.EncodeEntry(ent.Entry, ent.Fields)
+			if err != nil {
+				b.Fatal(err)
+			}
+			buf.Free()
+		}
+	}
+}
+
+func BenchmarkEncodeEntries(b *testing.B) {
+	entries := benchmarkEntries(100)
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), testutil.NewFakeLogger(), stackdriver.NewStackdriverEncoderConfig()).(*stackdriver.Encoder)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		bufs, err := enc.EncodeEntries(entries)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, buf := range bufs {
+			buf.Free()
+		}
+	}
+}
+
+func BenchmarkEncodeEntryErrorLevel(b *testing.B) {
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), testutil.NewFakeLogger(), stackdriver.NewStackdriverEncoderConfig(),
+		stackdriver.WithSourceLocation(true),
+	).(*stackdriver.Encoder)
+	enc.SetReportLocation = true
+	ent := zapcore.Entry{
+		Level:   zapcore.ErrorLevel,
+		Message: "failed",
+		Caller:  zapcore.NewEntryCaller(reflect.ValueOf(BenchmarkEncodeEntryErrorLevel).Pointer(), "/go/src/app/main.go", 142, true),
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		buf, err := enc.EncodeEntry(ent, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		buf.Free()
+	}
+}
+
+func BenchmarkEncodeEntrySlice(b *testing.B) {
+	ints := []int{1, 2, 3, 4, 5, 6, 7, 8}
+	strs := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Message: "slices"}
+
+	benchmarks := []struct {
+		name   string
+		enc    zapcore.Encoder
+		fields []zapcore.Field
+	}{
+		{
+			name:   "JSONReflect",
+			enc:    zapcore.NewJSONEncoder(stackdriver.NewStackdriverEncoderConfig()),
+			fields: []zapcore.Field{zap.Reflect("ints", ints), zap.Reflect("strs", strs)},
+		},
+		{
+			name:   "Reflect",
+			enc:    stackdriver.NewStackdriverEncoder(context.Background(), testutil.NewFakeLogger(), stackdriver.NewStackdriverEncoderConfig()),
+			fields: []zapcore.Field{zap.Reflect("ints", ints), zap.Reflect("strs", strs)},
+		},
+		{
+			name:   "Array",
+			enc:    stackdriver.NewStackdriverEncoder(context.Background(), testutil.NewFakeLogger(), stackdriver.NewStackdriverEncoderConfig()),
+			fields: []zapcore.Field{zap.Ints("ints", ints), zap.Strings("strs", strs)},
+		},
+	}
+	for _, bb := range benchmarks {
+		bb := bb
+		b.Run(bb.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf, err := bb.enc.EncodeEntry(ent, bb.fields)
+				if err != nil {
+					b.Fatal(err)
+				}
+				buf.Free()
+			}
+		})
+	}
+}
@@ -7,11 +7,19 @@ package stackdriver_test
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	sdlogging "cloud.google.com/go/logging"
 	"github.com/google/go-cmp/cmp"
+	"go.opencensus.io/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
@@ -24,6 +32,32 @@ const (
 	testLogIDPrefix = "go-logging-client/test-log"
 )
 
+// newTestLogger returns the stackdriver logging client if the test project is
+// configured, otherwise returns the in-memory fake logger.
+func newTestLogger(ctx context.Context, projectID, logID string) stackdriver.Logger {
+	if projectID == "" {
+		return testutil.NewFakeLogger()
+	}
+
+	return stackdriver.NewDefaultStackdriverClient(ctx, projectID, logID)
+}
+
+// decodePayload decodes the JSON payload of the delivered entry.
+func decodePayload(t *testing.T, entry sdlogging.Entry) map[string]interface{} {
+	t.Helper()
+
+	s, ok := entry.Payload.(string)
+	if !ok {
+		t.Fatalf("unexpected payload type: %T", entry.Payload)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &payload); err != nil {
+		t.Fatalf("payload (%q) is not valid json: %+v", s, err)
+	}
+
+	return payload
+}
+
 func TestStackdriverEncodeEntry(t *testing.T) {
 	ctx := context.Background()
 	testProjectID := testutil.ProjectID()
@@ -96,7 +130,7 @@ func TestStackdriverEncodeEntry(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			lg := stackdriver.NewDefaultStackdriverClient(ctx, testProjectID, testLogID)
+			lg := newTestLogger(ctx, testProjectID, testLogID)
 			enc := stackdriver.NewStackdriverEncoder(ctx, lg, stackdriver.NewStackdriverEncoderConfig())
 			buf, err := enc.EncodeEntry(tt.ent, tt.fields)
 			if err != nil {
@@ -121,3 +155,1011 @@ func TestStackdriverEncodeEntry(t *testing.T) {
 		})
 	}
 }
+
+func TestEncoderMaxFieldValueLength(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+		stackdriver.WithMaxFieldValueLength(8),
+	)
+
+	// the fields added by the zap.Logger.With are truncated as well.
+	child := enc.Clone()
+	zap.String("withBlob", strings.Repeat("W", 64)).AddTo(child)
+	zap.ByteString("withBytes", []byte(strings.Repeat("V", 64))).AddTo(child)
+
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Message: "blob"}
+	fields := []zapcore.Field{
+		zap.String("short", "abc"),
+		zap.String("exact", "12345678"),
+		zap.String("blob", strings.Repeat("A", 1<<20)),
+		zap.Int("count", 3),
+		zap.ByteString("bytes", []byte(strings.Repeat("B", 64))),
+		zap.Stringer("elapsed", 1281023*time.Hour),
+	}
+	buf, err := child.EncodeEntry(ent, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Free()
+
+	entries := lg.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	payload := decodePayload(t, entries[0])
+
+	want := map[string]interface{}{
+		"short":     "abc",
+		"exact":     "12345678",
+		"blob":      "AAAAAAAA…",
+		"count":     float64(3),
+		"bytes":     "BBBBBBBB…",
+		"elapsed":   "1281023h…",
+		"withBlob":  "WWWWWWWW…",
+		"withBytes": "VVVVVVVV…",
+	}
+	for k, v := range want {
+		if got := payload[k]; got != v {
+			t.Errorf("%s: got %v, want %v", k, got, v)
+		}
+	}
+	if got := fields[2].String; len(got) != 1<<20 {
+		t.Errorf("caller's field was modified: got length %d", len(got))
+	}
+}
+
+func TestNewStackdriverEncoderFromConfig(t *testing.T) {
+	cfg := zap.NewProductionConfig()
+	cfg.EncoderConfig.MessageKey = "msg"
+	cfg.EncoderConfig.TimeKey = ""
+
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoderFromConfig(context.Background(), lg, cfg)
+
+	ent := zapcore.Entry{
+		Level:   zapcore.WarnLevel,
+		Time:    time.Date(2018, 6, 19, 16, 33, 42, 0, time.UTC),
+		Message: "lob law",
+	}
+	buf, err := enc.EncodeEntry(ent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Free()
+
+	payload := decodePayload(t, lg.Entries()[0])
+	want := map[string]interface{}{
+		"msg":       "lob law",
+		"severity":  "WARNING",
+		"eventTime": float64(1529426022), // zap.NewProductionConfig uses the epoch time encoder
+	}
+	for k, v := range want {
+		if got := payload[k]; got != v {
+			t.Errorf("%s: got %v, want %v", k, got, v)
+		}
+	}
+	for _, k := range []string{"message", "level"} {
+		if _, ok := payload[k]; ok {
+			t.Errorf("unexpected %q key in payload: %v", k, payload)
+		}
+	}
+}
+
+func TestEncoderFlushOnFatal(t *testing.T) {
+	tests := []struct {
+		level zapcore.Level
+		want  int
+	}{
+		{level: zapcore.ErrorLevel, want: 0},
+		{level: zapcore.DPanicLevel, want: 1},
+		{level: zapcore.PanicLevel, want: 1},
+		{level: zapcore.FatalLevel, want: 1},
+		{level: zapcore.FatalLevel + 1, want: 0},
+		{level: zapcore.InfoLevel + 10, want: 0},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.level.String(), func(t *testing.T) {
+			t.Parallel()
+
+			lg := testutil.NewFakeLogger()
+			enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig())
+			buf, err := enc.EncodeEntry(zapcore.Entry{Level: tt.level, Message: "last words"}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer buf.Free()
+
+			if got := len(lg.Entries()); got != 1 {
+				t.Fatalf("got %d entries, want 1", got)
+			}
+			if got := lg.Flushes(); got != tt.want {
+				t.Errorf("got %d flushes, want %d", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("flush error", func(t *testing.T) {
+		t.Parallel()
+
+		var handled []error
+		lg := testutil.NewFakeLogger()
+		lg.FlushErr = errors.New("unavailable")
+		enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+			stackdriver.WithErrorHandler(func(err error) { handled = append(handled, err) }),
+		)
+		buf, err := enc.EncodeEntry(zapcore.Entry{Level: zapcore.FatalLevel, Message: "last words"}, nil)
+		if err != nil {
+			t.Fatalf("got error %v, want the entry encoded for the core", err)
+		}
+		if !strings.Contains(buf.String(), "last words") {
+			t.Errorf("got encoded entry %q, want the last words", buf.String())
+		}
+		buf.Free()
+		if len(handled) != 1 || handled[0] != lg.FlushErr {
+			t.Errorf("got handled errors %v, want [%v]", handled, lg.FlushErr)
+		}
+		if got := enc.(*stackdriver.Encoder).Stats().DeliveryErrors; got != 1 {
+			t.Errorf("got %d delivery errors, want 1", got)
+		}
+	})
+}
+
+func TestEncoderWithLoggerThresholds(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+		stackdriver.WithLoggerThresholds(map[string]zapcore.Level{
+			"cache":    zapcore.WarnLevel,
+			"payments": zapcore.InfoLevel,
+		}),
+	)
+
+	tests := []struct {
+		name  string
+		level zapcore.Level
+		want  bool
+	}{
+		{name: "cache", level: zapcore.InfoLevel, want: false},
+		{name: "cache", level: zapcore.WarnLevel, want: true},
+		{name: "cache", level: zapcore.ErrorLevel, want: true},
+		{name: "payments", level: zapcore.DebugLevel, want: false},
+		{name: "payments", level: zapcore.InfoLevel, want: true},
+		{name: "", level: zapcore.DebugLevel, want: true},
+	}
+	for _, tt := range tests {
+		before := len(lg.Entries())
+
+		buf, err := enc.EncodeEntry(zapcore.Entry{LoggerName: tt.name, Level: tt.level, Message: "msg"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() == 0 {
+			t.Errorf("%s/%s: the entry was not encoded", tt.name, tt.level)
+		}
+		buf.Free()
+
+		if got := len(lg.Entries()) > before; got != tt.want {
+			t.Errorf("%s/%s: got delivered %t, want %t", tt.name, tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestEncoderWithEntryFilter(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+		stackdriver.WithEntryFilter(func(ent zapcore.Entry, _ []zapcore.Field) bool {
+			return ent.Message != "healthz"
+		}),
+	)
+
+	tests := []struct {
+		msg   string
+		level zapcore.Level
+		want  bool
+	}{
+		{msg: "healthz", level: zapcore.InfoLevel, want: false},
+		{msg: "healthz", level: zapcore.ErrorLevel, want: false},
+		{msg: "request", level: zapcore.InfoLevel, want: true},
+	}
+	for _, tt := range tests {
+		before := len(lg.Entries())
+
+		buf, err := enc.EncodeEntry(zapcore.Entry{Level: tt.level, Message: tt.msg}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.Len() > 0; got != tt.want {
+			t.Errorf("%s/%s: got encoded %t, want %t", tt.msg, tt.level, got, tt.want)
+		}
+		buf.Free()
+
+		if got := len(lg.Entries()) > before; got != tt.want {
+			t.Errorf("%s/%s: got delivered %t, want %t", tt.msg, tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestEncoderWithDisabled(t *testing.T) {
+	for _, disabled := range []bool{true, false} {
+		lg := testutil.NewFakeLogger()
+		enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+			stackdriver.WithDisabled(disabled),
+		)
+		for _, lv := range []zapcore.Level{zapcore.InfoLevel, zapcore.PanicLevel} {
+			buf, err := enc.EncodeEntry(zapcore.Entry{Level: lv, Message: "msg"}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(buf.String(), `"message":"msg"`) {
+				t.Errorf("disabled %t: got buffer %q, want the encoded entry", disabled, buf.String())
+			}
+			buf.Free()
+		}
+
+		want := 2
+		if disabled {
+			want = 0
+		}
+		if got := len(lg.Entries()); got != want {
+			t.Errorf("disabled %t: got %d delivered entries, want %d", disabled, got, want)
+		}
+		if got := lg.Flushes(); disabled && got != 0 {
+			t.Errorf("disabled %t: got %d flushes, want 0", disabled, got)
+		}
+	}
+}
+
+func TestEncoderSetSeverityFloor(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+		stackdriver.WithSeverityFloor(zapcore.WarnLevel),
+	).(*stackdriver.Encoder)
+	child := enc.Clone()
+
+	logAll := func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for _, lv := range []zapcore.Level{zapcore.DebugLevel, zapcore.InfoLevel, zapcore.ErrorLevel} {
+					for _, e := range []zapcore.Encoder{enc, child} {
+						buf, err := e.EncodeEntry(zapcore.Entry{Level: lv, Message: "msg"}, nil)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						buf.Free()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	// the floor changes while the entries are being logged.
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				enc.SetSeverityFloor(zapcore.Level(i%3) - 1)
+			}
+		}
+	}()
+	logAll()
+	close(stop)
+	<-done
+
+	tests := []struct {
+		floor zapcore.Level
+		want  map[sdlogging.Severity]int
+	}{
+		{floor: zapcore.DebugLevel, want: map[sdlogging.Severity]int{sdlogging.Debug: 16, sdlogging.Info: 16, sdlogging.Error: 16}},
+		{floor: zapcore.InfoLevel, want: map[sdlogging.Severity]int{sdlogging.Info: 16, sdlogging.Error: 16}},
+		{floor: zapcore.ErrorLevel, want: map[sdlogging.Severity]int{sdlogging.Error: 16}},
+	}
+	for _, tt := range tests {
+		enc.SetSeverityFloor(tt.floor)
+		if got := enc.SeverityFloor(); got != tt.floor {
+			t.Errorf("SeverityFloor: got %s, want %s", got, tt.floor)
+		}
+
+		before := len(lg.Entries())
+		logAll()
+
+		got := make(map[sdlogging.Severity]int)
+		for _, entry := range lg.Entries()[before:] {
+			got[entry.Severity]++
+		}
+		if diff := cmp.Diff(got, tt.want); diff != "" {
+			t.Errorf("floor %s: (-got, +want)\n%s", tt.floor, diff)
+		}
+	}
+}
+
+func TestEncoderDefaultSeverityFloor(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig()).(*stackdriver.Encoder)
+
+	buf, err := enc.EncodeEntry(zapcore.Entry{Level: zapcore.DebugLevel - 1, Message: "trace"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Free()
+
+	if got := len(lg.Entries()); got != 1 {
+		t.Fatalf("got %d entries, want the entry below DebugLevel delivered", got)
+	}
+	if got, want := enc.SeverityFloor(), zapcore.Level(math.MinInt8); got != want {
+		t.Errorf("SeverityFloor: got %s, want %s", got, want)
+	}
+}
+
+func TestEncoderPayloadTooLarge(t *testing.T) {
+	large := strings.Repeat("x", stackdriver.MaxPayloadSize)
+
+	var handled []error
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+		stackdriver.WithErrorHandler(func(err error) { handled = append(handled, err) }),
+	)
+	buf, err := enc.EncodeEntry(zapcore.Entry{Message: "msg"}, []zapcore.Field{zap.String("large", large)})
+	if err != nil {
+		t.Fatalf("got error %v, want the entry encoded for the core", err)
+	}
+	if buf == nil || !strings.Contains(buf.String(), `"message":"msg"`) {
+		t.Fatal("got no encoded entry")
+	}
+	buf.Free()
+	if len(handled) != 1 {
+		t.Fatalf("got handled errors %v, want one", handled)
+	}
+	err = handled[0]
+	if !errors.Is(err, stackdriver.ErrPayloadTooLarge) {
+		t.Fatalf("got error %v, want ErrPayloadTooLarge", err)
+	}
+	var tooLarge *stackdriver.PayloadTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("got error %T, want *PayloadTooLargeError", err)
+	}
+	if tooLarge.Size <= stackdriver.MaxPayloadSize || tooLarge.Limit != stackdriver.MaxPayloadSize {
+		t.Errorf("got size %d and limit %d", tooLarge.Size, tooLarge.Limit)
+	}
+	if got := len(lg.Entries()); got != 0 {
+		t.Errorf("got %d delivered entries, want 0", got)
+	}
+
+	// the truncation guard keeps the entry deliverable.
+	enc = stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+		stackdriver.WithMaxFieldValueLength(1024),
+		stackdriver.WithErrorHandler(func(err error) { handled = append(handled, err) }),
+	)
+	buf, err = enc.EncodeEntry(zapcore.Entry{Message: "msg"}, []zapcore.Field{zap.String("large", large)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Free()
+	if len(handled) != 1 {
+		t.Errorf("got handled errors %v, want none", handled[1:])
+	}
+	if got := len(lg.Entries()); got != 1 {
+		t.Errorf("got %d delivered entries, want 1", got)
+	}
+}
+
+func TestEncoderZeroTime(t *testing.T) {
+	now := time.Date(2018, 6, 19, 16, 33, 42, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		opts []stackdriver.Option
+		time time.Time
+		want func(time.Time) bool
+	}{
+		{
+			name: "clock",
+			opts: []stackdriver.Option{stackdriver.WithClock(func() time.Time { return now })},
+			want: func(ts time.Time) bool { return ts.Equal(now) },
+		},
+		{
+			name: "default clock",
+			want: func(ts time.Time) bool { return time.Since(ts) < time.Minute },
+		},
+		{
+			name: "entry time",
+			opts: []stackdriver.Option{stackdriver.WithClock(func() time.Time { return now })},
+			time: now.Add(-time.Hour),
+			want: func(ts time.Time) bool { return ts.Equal(now.Add(-time.Hour)) },
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			lg := testutil.NewFakeLogger()
+			enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(), tt.opts...)
+			buf, err := enc.EncodeEntry(zapcore.Entry{Time: tt.time, Message: "msg"}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer buf.Free()
+
+			entry := lg.Entries()[0]
+			if !tt.want(entry.Timestamp) {
+				t.Errorf("got timestamp %s", entry.Timestamp)
+			}
+			eventTime, err := time.Parse("2006-01-02T15:04:05.000Z0700", decodePayload(t, entry)["eventTime"].(string))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !eventTime.Equal(entry.Timestamp.Truncate(time.Millisecond)) {
+				t.Errorf("got eventTime %s, want %s", eventTime, entry.Timestamp)
+			}
+		})
+	}
+}
+
+func TestEncoderPayloadLineEnding(t *testing.T) {
+	for _, lineEnding := range []string{"", "\n", "\r\n"} {
+		cfg := stackdriver.NewStackdriverEncoderConfig()
+		cfg.LineEnding = lineEnding
+
+		lg := testutil.NewFakeLogger()
+		enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, cfg)
+		buf, err := enc.EncodeEntry(zapcore.Entry{Message: "msg"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := lineEnding
+		if want == "" {
+			want = zapcore.DefaultLineEnding
+		}
+		if !strings.HasSuffix(buf.String(), "}"+want) {
+			t.Errorf("%q: buffer has no line ending: %q", lineEnding, buf.String())
+		}
+		if payload := lg.Entries()[0].Payload.(string); !strings.HasSuffix(payload, "}") {
+			t.Errorf("%q: payload has a trailing line ending: %q", lineEnding, payload)
+		}
+		buf.Free()
+	}
+}
+
+func TestEncoderTimestampAlias(t *testing.T) {
+	now := time.Date(2018, 6, 19, 16, 33, 42, 0, time.UTC)
+
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(), stackdriver.WithTimestampAlias("timestamp"))
+	buf, err := enc.EncodeEntry(zapcore.Entry{Time: now, Message: "msg"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Free()
+
+	payload := decodePayload(t, lg.Entries()[0])
+	for _, key := range []string{"eventTime", "timestamp"} {
+		if got, want := payload[key], "2018-06-19T16:33:42.000Z"; got != want {
+			t.Errorf("got %s %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestEncoderTimestampPrecision(t *testing.T) {
+	tm := time.Date(2018, 6, 19, 16, 33, 42, 678e6, time.UTC)
+
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(), stackdriver.WithTimestampPrecision(time.Second))
+	buf, err := enc.EncodeEntry(zapcore.Entry{Time: tm, Message: "msg"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Free()
+
+	entry := lg.Entries()[0]
+	if got, want := decodePayload(t, entry)["eventTime"], "2018-06-19T16:33:43.000Z"; got != want {
+		t.Errorf("got eventTime %v, want %v", got, want)
+	}
+	if want := time.Date(2018, 6, 19, 16, 33, 43, 0, time.UTC); !entry.Timestamp.Equal(want) {
+		t.Errorf("got timestamp %v, want %v", entry.Timestamp, want)
+	}
+}
+
+func TestEncoderReceiveTimestamp(t *testing.T) {
+	now := time.Date(2018, 6, 19, 16, 33, 42, 0, time.UTC)
+
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+		stackdriver.WithReceiveTimestamp(true),
+		stackdriver.WithClock(func() time.Time { return now }),
+	)
+	buf, err := enc.EncodeEntry(zapcore.Entry{Time: now.Add(-1500 * time.Millisecond), Message: "msg"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Free()
+
+	payload := decodePayload(t, lg.Entries()[0])
+	if got, want := payload["eventTime"], "2018-06-19T16:33:40.500Z"; got != want {
+		t.Errorf("got eventTime %v, want %v", got, want)
+	}
+	if got, want := payload["receiveTimestamp"], "2018-06-19T16:33:42.000Z"; got != want {
+		t.Errorf("got receiveTimestamp %v, want %v", got, want)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	if err := stackdriver.ValidateConfig(stackdriver.NewStackdriverConfig()); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	for _, encoding := range []string{"", "stackdriver-unregistered"} {
+		cfg := stackdriver.NewStackdriverConfig()
+		cfg.Encoding = encoding
+
+		err := stackdriver.ValidateConfig(cfg)
+		if err == nil {
+			t.Fatalf("%q: got nil, want error", encoding)
+		}
+		if !strings.Contains(err.Error(), fmt.Sprintf("encoding %q", encoding)) {
+			t.Errorf("%q: got %v, want the encoding in the error", encoding, err)
+		}
+	}
+}
+
+func TestEncoderMessageTemplate(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []zapcore.Field
+		want   string
+	}{
+		{
+			name:   "rendered",
+			fields: []zapcore.Field{zap.String("method", "GET"), zap.String("path", "/users/{id}"), zap.Int("status", 404)},
+			want:   "GET /users/{id} -> 404",
+		},
+		{
+			name:   "missing field",
+			fields: []zapcore.Field{zap.String("method", "GET"), zap.String("path", "/users")},
+			want:   "msg",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			lg := testutil.NewFakeLogger()
+			logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel, stackdriver.WithMessageTemplate("{method} {path} -> {status}"))
+			logger.Info("msg", tt.fields...)
+
+			if got := decodePayload(t, lg.Entries()[0])["message"]; got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncoderDurationEncoding(t *testing.T) {
+	tests := []struct {
+		unit string
+		want interface{}
+	}{
+		{unit: "s", want: 1.5},
+		{unit: "ms", want: float64(1500)},
+		{unit: "ns", want: float64(1500 * time.Millisecond)},
+		{unit: "unknown", want: 1.5}, // the default of NewStackdriverEncoderConfig
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.unit, func(t *testing.T) {
+			lg := testutil.NewFakeLogger()
+			logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel, stackdriver.WithDurationEncoding(tt.unit))
+			logger.Info("msg", zap.Duration("latency", 1500*time.Millisecond))
+
+			payload := lg.Entries()[0].Payload.(string)
+			if got := decodePayload(t, lg.Entries()[0])["latency"]; got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if tt.unit == "ms" && !strings.Contains(payload, `"latency":1500}`) {
+				t.Errorf("got %s, want the integer milliseconds", payload)
+			}
+		})
+	}
+}
+
+// countingIDGenerator counts the spans started by the tracer.
+type countingIDGenerator struct {
+	spans int32
+}
+
+func (g *countingIDGenerator) NewSpanID() (id [8]byte) {
+	atomic.AddInt32(&g.spans, 1)
+	id[7] = 1
+	return id
+}
+
+func (g *countingIDGenerator) NewTraceID() (id [16]byte) {
+	id[15] = 1
+	return id
+}
+
+func TestContextFuncThrowawaySpan(t *testing.T) {
+	gen := &countingIDGenerator{}
+	trace.ApplyConfig(trace.Config{IDGenerator: gen})
+
+	tests := []struct {
+		name      string
+		opts      []stackdriver.ClientOption
+		wantSpans int32
+	}{
+		{name: "default", wantSpans: 1},
+		{name: "without throwaway span", opts: []stackdriver.ClientOption{stackdriver.WithoutThrowawaySpan()}, wantSpans: 0},
+	}
+	for _, tt := range tests {
+		atomic.StoreInt32(&gen.spans, 0)
+
+		ctx, done := stackdriver.ContextFunc(context.Background(), tt.opts...)()
+		if _, ok := ctx.Deadline(); !ok {
+			t.Errorf("%s: got no deadline, want the timeout context", tt.name)
+		}
+		done()
+
+		if got := atomic.LoadInt32(&gen.spans); got != tt.wantSpans {
+			t.Errorf("%s: got %d spans started, want %d", tt.name, got, tt.wantSpans)
+		}
+	}
+}
+
+func TestLogName(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []stackdriver.ClientOption
+		wantParent string
+		wantID     string
+	}{
+		{name: "default", wantParent: "my-project", wantID: "app"},
+		{
+			name:       "fully-qualified",
+			opts:       []stackdriver.ClientOption{stackdriver.WithLogName("projects/other-project/logs/audit%2Fwrites")},
+			wantParent: "projects/other-project",
+			wantID:     "audit/writes",
+		},
+		{
+			name:       "not fully-qualified",
+			opts:       []stackdriver.ClientOption{stackdriver.WithLogName("audit")},
+			wantParent: "my-project",
+			wantID:     "app",
+		},
+	}
+	for _, tt := range tests {
+		parent, id := stackdriver.LogName("my-project", "app", tt.opts...)
+		if parent != tt.wantParent || id != tt.wantID {
+			t.Errorf("%s: got (%q, %q), want (%q, %q)", tt.name, parent, id, tt.wantParent, tt.wantID)
+		}
+	}
+}
+
+func TestEncoderEncodeEntries(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig()).(*stackdriver.Encoder)
+
+	entries := []stackdriver.EntryWithFields{
+		{Entry: zapcore.Entry{Level: zapcore.InfoLevel, Message: "first"}, Fields: []zapcore.Field{zap.Int("line", 1)}},
+		{Entry: zapcore.Entry{Level: zapcore.WarnLevel, Message: "second"}, Fields: []zapcore.Field{zap.Int("line", 2)}},
+		{Entry: zapcore.Entry{Level: zapcore.ErrorLevel, Message: "third"}, Fields: []zapcore.Field{zap.Int("line", 3)}},
+	}
+	bufs, err := enc.EncodeEntries(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bufs) != len(entries) {
+		t.Fatalf("got %d buffers, want %d", len(bufs), len(entries))
+	}
+
+	delivered := lg.Entries()
+	if len(delivered) != len(entries) {
+		t.Fatalf("got %d entries delivered, want %d", len(delivered), len(entries))
+	}
+	for i, entry := range delivered {
+		payload := decodePayload(t, entry)
+		if got, want := payload["message"], entries[i].Entry.Message; got != want {
+			t.Errorf("#%d: got message %v, want %v", i, got, want)
+		}
+		if got, want := payload["line"], float64(i+1); got != want {
+			t.Errorf("#%d: got line %v, want %v", i, got, want)
+		}
+		if got, want := bufs[i].String(), entry.Payload.(string)+"\n"; got != want {
+			t.Errorf("#%d: got buffer %q, want %q", i, got, want)
+		}
+		bufs[i].Free()
+	}
+}
+
+func TestEncoderStructuredStacktrace(t *testing.T) {
+	const stack = "main.handler\n" +
+		"\t/go/src/example.com/app/handler.go:42\n" +
+		"net/http.HandlerFunc.ServeHTTP\n" +
+		"\t/usr/local/go/src/net/http/server.go:1964\n" +
+		"runtime.goexit\n" +
+		"\t/usr/local/go/src/runtime/asm_amd64.s:1333"
+
+	tests := []struct {
+		name  string
+		stack string
+		want  interface{}
+	}{
+		{
+			name:  "zap format",
+			stack: stack,
+			want: []interface{}{
+				map[string]interface{}{"function": "main.handler", "file": "/go/src/example.com/app/handler.go", "line": float64(42)},
+				map[string]interface{}{"function": "net/http.HandlerFunc.ServeHTTP", "file": "/usr/local/go/src/net/http/server.go", "line": float64(1964)},
+				map[string]interface{}{"function": "runtime.goexit", "file": "/usr/local/go/src/runtime/asm_amd64.s", "line": float64(1333)},
+			},
+		},
+		{
+			name:  "malformed",
+			stack: "goroutine 1 [running]:",
+			want:  "goroutine 1 [running]:",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			lg := testutil.NewFakeLogger()
+			enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+				stackdriver.WithStructuredStacktrace(true),
+			)
+			buf, err := enc.EncodeEntry(zapcore.Entry{Level: zapcore.ErrorLevel, Message: "msg", Stack: tt.stack}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer buf.Free()
+
+			if diff := cmp.Diff(decodePayload(t, lg.Entries()[0])["trace"], tt.want); diff != "" {
+				t.Errorf("(-got, +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEncoderDefaultSeverity(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []stackdriver.Option
+		level      zapcore.Level
+		want       sdlogging.Severity
+		wantString string
+	}{
+		{name: "unknown level", level: zapcore.Level(42), want: sdlogging.Default, wantString: "DEFAULT"},
+		{name: "unknown level with default", opts: []stackdriver.Option{stackdriver.WithDefaultSeverity(sdlogging.Error)}, level: zapcore.Level(42), want: sdlogging.Error, wantString: "ERROR"},
+		{name: "known level with default", opts: []stackdriver.Option{stackdriver.WithDefaultSeverity(sdlogging.Error)}, level: zapcore.InfoLevel, want: sdlogging.Info, wantString: "INFO"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			lg := testutil.NewFakeLogger()
+			enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(), tt.opts...)
+			buf, err := enc.EncodeEntry(zapcore.Entry{Level: tt.level, Message: "msg"}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer buf.Free()
+
+			if got := lg.Entries()[0].Severity; got != tt.want {
+				t.Errorf("got severity %v, want %v", got, tt.want)
+			}
+			if got := decodePayload(t, lg.Entries()[0])["severity"]; got != tt.wantString {
+				t.Errorf("got payload severity %v, want %q", got, tt.wantString)
+			}
+		})
+	}
+}
+
+func TestEncoderDisableHTMLEscape(t *testing.T) {
+	type link struct {
+		URL string `json:"url"`
+	}
+	const url = "https://example.com/search?q=<tag>&page=2"
+
+	for _, disable := range []bool{false, true} {
+		lg := testutil.NewFakeLogger()
+		logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel, stackdriver.WithDisableHTMLEscape(disable))
+		logger.With(zap.Any("with", link{URL: url})).Info("msg", zap.Any("link", link{URL: url}), zap.String("url", url))
+
+		payload := lg.Entries()[0].Payload.(string)
+		// zap.Any of a struct is reflected, and escaped by encoding/json unless disabled.
+		for _, key := range []string{"with", "link"} {
+			if got := strings.Contains(payload, `"`+key+`":{"url":"`+url+`"}`); got != disable {
+				t.Errorf("disable=%t: got %s, want %s unescaped=%t", disable, payload, key, disable)
+			}
+		}
+		if !strings.Contains(payload, `"url":"`+url+`"`) {
+			t.Errorf("disable=%t: got %s, want the string field unescaped", disable, payload)
+		}
+	}
+}
+
+// celsiusEncoder renders the float64 values in Celsius and the others by encoding/json.
+type celsiusEncoder struct {
+	w io.Writer
+}
+
+func (enc celsiusEncoder) Encode(v interface{}) error {
+	if f, ok := v.(float64); ok {
+		_, err := fmt.Fprintf(enc.w, `"%.1f°C"`, f)
+		return err
+	}
+	return json.NewEncoder(enc.w).Encode(v)
+}
+
+func TestEncoderReflectedEncoder(t *testing.T) {
+	type reading struct {
+		Sensor string `json:"sensor"`
+	}
+
+	lg := testutil.NewFakeLogger()
+	logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel,
+		stackdriver.WithReflectedEncoder(func(w io.Writer) stackdriver.ReflectedEncoder { return celsiusEncoder{w: w} }),
+	)
+	logger.With(zap.Reflect("outside", 12.25)).Info("msg",
+		zap.Reflect("inside", 21.5),
+		zap.Any("reading", reading{Sensor: "s1"}),
+		zap.Float64("raw", 1.5),
+	)
+
+	payload := decodePayload(t, lg.Entries()[0])
+	for key, want := range map[string]interface{}{
+		"outside": "12.2°C",
+		"inside":  "21.5°C",
+		"reading": map[string]interface{}{"sensor": "s1"},
+		"raw":     1.5,
+	} {
+		if diff := cmp.Diff(payload[key], want); diff != "" {
+			t.Errorf("%s: (-got, +want)\n%s", key, diff)
+		}
+	}
+}
+
+func TestEncoderSkipFields(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel, stackdriver.WithDedupeFields(stackdriver.DedupeLast))
+	logger.Info("msg", zap.Skip(), zap.String("context.user", "alice"), zap.Skip(), zap.Int("n", 1), zap.Skip())
+
+	payload := decodePayload(t, lg.Entries()[0])
+	if _, ok := payload[""]; ok {
+		t.Errorf("payload has the empty key: %v", payload)
+	}
+	if got := payload["n"]; got != float64(1) {
+		t.Errorf("got n %v, want 1", got)
+	}
+	ctx, ok := payload["context"].(map[string]interface{})
+	if !ok || ctx["user"] != "alice" {
+		t.Errorf("got context %v, want the user alice", payload["context"])
+	}
+}
+
+func TestEncoderSeverityMap(t *testing.T) {
+	const noticeLevel = zapcore.InfoLevel + 10
+
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+		stackdriver.WithSeverityMap(map[zapcore.Level]sdlogging.Severity{noticeLevel: sdlogging.Notice}))
+	for _, lv := range []zapcore.Level{noticeLevel, zapcore.WarnLevel} {
+		buf, err := enc.EncodeEntry(zapcore.Entry{Level: lv, Message: "msg"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Free()
+	}
+
+	tests := []struct {
+		want       sdlogging.Severity
+		wantString string
+	}{
+		{want: sdlogging.Notice, wantString: "NOTICE"},
+		{want: sdlogging.Warning, wantString: "WARNING"},
+	}
+	entries := lg.Entries()
+	for i, tt := range tests {
+		if got := entries[i].Severity; got != tt.want {
+			t.Errorf("#%d: got severity %v, want %v", i, got, tt.want)
+		}
+		if got := decodePayload(t, entries[i])["severity"]; got != tt.wantString {
+			t.Errorf("#%d: got payload severity %v, want %q", i, got, tt.wantString)
+		}
+	}
+}
+
+func TestEncoderSeverityMapper(t *testing.T) {
+	const noticeLevel = zapcore.InfoLevel + 10
+
+	mapper := func(lv zapcore.Level) sdlogging.Severity {
+		switch lv {
+		case zapcore.DPanicLevel:
+			return sdlogging.Error
+		case noticeLevel:
+			return sdlogging.Notice
+		}
+		return sdlogging.Info
+	}
+
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+		stackdriver.WithSeverityMap(map[zapcore.Level]sdlogging.Severity{zapcore.WarnLevel: sdlogging.Notice}),
+	).(*stackdriver.Encoder)
+	// the mapper set after Clone applies to the clone only, e.g. of the zap.Logger.With.
+	clone := enc.Clone().(*stackdriver.Encoder)
+	clone.SeverityMapper = mapper
+	clone.AddString("user", "bob")
+	for _, e := range []zapcore.Encoder{clone, enc} {
+		for _, lv := range []zapcore.Level{zapcore.DPanicLevel, noticeLevel, zapcore.WarnLevel, zapcore.ErrorLevel} {
+			buf, err := e.EncodeEntry(zapcore.Entry{Level: lv, Message: "msg"}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf.Free()
+		}
+	}
+
+	tests := []struct {
+		want       sdlogging.Severity
+		wantString string
+	}{
+		{want: sdlogging.Error, wantString: "ERROR"},
+		{want: sdlogging.Notice, wantString: "NOTICE"},
+		{want: sdlogging.Notice, wantString: "NOTICE"},
+		{want: sdlogging.Info, wantString: "INFO"},
+		// the encoder cloned from keeps the default mapping.
+		{want: sdlogging.Critical, wantString: "CRITICAL"},
+		{want: sdlogging.Default, wantString: "DEFAULT"},
+		{want: sdlogging.Notice, wantString: "NOTICE"},
+		{want: sdlogging.Error, wantString: "ERROR"},
+	}
+	entries := lg.Entries()
+	if len(entries) != len(tests) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(tests))
+	}
+	for i, tt := range tests {
+		if got := entries[i].Severity; got != tt.want {
+			t.Errorf("#%d: got severity %v, want %v", i, got, tt.want)
+		}
+		payload := decodePayload(t, entries[i])
+		if got := payload["severity"]; got != tt.wantString {
+			t.Errorf("#%d: got payload severity %v, want %q", i, got, tt.wantString)
+		}
+		if i < 4 && payload["user"] != "bob" {
+			t.Errorf("#%d: got payload %v, want the user of the clone", i, payload)
+		}
+	}
+}
+
+func TestEncoderNativeSlices(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel)
+	logger.With(zap.Reflect("withInts", []int{4, 5})).Info("msg",
+		zap.Reflect("ints", []int{1, 2, 3}),
+		zap.Any("strs", []string{"a", "<b>"}),
+		zap.Reflect("nilInts", []int(nil)),
+	)
+
+	want := map[string]interface{}{
+		"withInts": []interface{}{float64(4), float64(5)},
+		"ints":     []interface{}{float64(1), float64(2), float64(3)},
+		"strs":     []interface{}{"a", "<b>"},
+		"nilInts":  nil,
+	}
+	payload := decodePayload(t, lg.Entries()[0])
+	for key, v := range want {
+		got, ok := payload[key]
+		if !ok {
+			t.Errorf("%q is missing: %v", key, payload)
+			continue
+		}
+		if diff := cmp.Diff(got, v); diff != "" {
+			t.Errorf("%s: (-got, +want)\n%s", key, diff)
+		}
+	}
+}
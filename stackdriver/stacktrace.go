@@ -0,0 +1,81 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// WithStructuredStacktrace encodes the stacktrace of the entries as an array of the
+// {"function", "file", "line"} frames under the StacktraceKey of the EncoderConfig, instead
+// of the raw string, so the frames can be queried. The stacktraces which aren't in the zap
+// format are kept as is.
+func WithStructuredStacktrace(enable bool) Option {
+	return func(o *options) {
+		o.structuredStack = enable
+	}
+}
+
+// stackFrame is a frame of the stacktrace.
+type stackFrame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (f stackFrame) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("function", f.Function)
+	enc.AddString("file", f.File)
+	enc.AddInt("line", f.Line)
+
+	return nil
+}
+
+// stackFrames is the stacktrace parsed by parseStacktrace.
+type stackFrames []stackFrame
+
+// MarshalLogArray implements zapcore.ArrayMarshaler.
+func (frames stackFrames) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, f := range frames {
+		if err := enc.AppendObject(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseStacktrace parses the stacktrace taken by zap, which has a pair of lines for each frame:
+// the function name, and the tab indented "file:line", and reports whether stack is well-formed.
+func parseStacktrace(stack string) (stackFrames, bool) {
+	lines := strings.Split(strings.TrimRight(stack, "\n"), "\n")
+	if len(lines)%2 != 0 {
+		return nil, false
+	}
+
+	frames := make(stackFrames, 0, len(lines)/2)
+	for i := 0; i < len(lines); i += 2 {
+		fn, loc := lines[i], lines[i+1]
+		if fn == "" || !strings.HasPrefix(loc, "\t") {
+			return nil, false
+		}
+		loc = loc[1:]
+		j := strings.LastIndexByte(loc, ':')
+		if j < 0 {
+			return nil, false
+		}
+		line, err := strconv.Atoi(loc[j+1:])
+		if err != nil {
+			return nil, false
+		}
+		frames = append(frames, stackFrame{Function: fn, File: loc[:j], Line: line})
+	}
+
+	return frames, true
+}
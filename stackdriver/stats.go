@@ -0,0 +1,33 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"sync/atomic"
+)
+
+// Stats is a snapshot of the Encoder counters, which are shared by the cloned encoders.
+type Stats struct {
+	EntriesEncoded   uint64 // entries encoded into the payload
+	EntriesDelivered uint64 // entries passed to the Logger
+	DeliveryErrors   uint64 // errors detected before or during the delivery, e.g. PayloadTooLargeError
+}
+
+// encoderStats holds the Encoder counters.
+type encoderStats struct {
+	encoded   uint64 // atomic
+	delivered uint64 // atomic
+	errors    uint64 // atomic
+}
+
+// Stats returns the snapshot of the counters of e, e.g. to export them to a metrics system
+// without depending on it.
+func (e *Encoder) Stats() Stats {
+	return Stats{
+		EntriesEncoded:   atomic.LoadUint64(&e.opts.stats.encoded),
+		EntriesDelivered: atomic.LoadUint64(&e.opts.stats.delivered),
+		DeliveryErrors:   atomic.LoadUint64(&e.opts.stats.errors),
+	}
+}
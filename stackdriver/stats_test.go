@@ -0,0 +1,72 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/zchee/zap-encoder/internal/testutil"
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+func TestEncoderStats(t *testing.T) {
+	const (
+		goroutines = 8
+		n          = 100
+	)
+
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig(),
+		stackdriver.WithSeverityFloor(zapcore.InfoLevel),
+	).(*stackdriver.Encoder)
+	clone := enc.Clone().(*stackdriver.Encoder)
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				// delivered
+				if buf, err := clone.EncodeEntry(zapcore.Entry{Level: zapcore.InfoLevel, Message: "msg"}, nil); err == nil {
+					buf.Free()
+				}
+				// encoded, but below the severity floor
+				if buf, err := enc.EncodeEntry(zapcore.Entry{Level: zapcore.DebugLevel, Message: "msg"}, nil); err == nil {
+					buf.Free()
+				}
+				// payload too large
+				big := zap.String("big", strings.Repeat("x", stackdriver.MaxPayloadSize))
+				if buf, err := enc.EncodeEntry(zapcore.Entry{Level: zapcore.InfoLevel, Message: "msg"}, []zapcore.Field{big}); buf != nil {
+					buf.Free()
+				} else if err == nil {
+					t.Error("got no buffer and no error")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := stackdriver.Stats{
+		EntriesEncoded:   3 * goroutines * n,
+		EntriesDelivered: goroutines * n,
+		DeliveryErrors:   goroutines * n,
+	}
+	if got := enc.Stats(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := clone.Stats(); got != want {
+		t.Errorf("clone: got %+v, want %+v", got, want)
+	}
+	if got := len(lg.Entries()); got != goroutines*n {
+		t.Errorf("got %d entries delivered, want %d", got, goroutines*n)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// messageTemplate is the parsed template of WithMessageTemplate.
+type messageTemplate struct {
+	literals []string // len(literals) == len(names)+1
+	names    []string
+}
+
+// WithMessageTemplate synthesizes the entry message from the fields named in tmpl, e.g.
+// "{method} {path} -> {status}", where each "{name}" is replaced by the value of the field
+// whose key is name. The entries which lack any of the named fields keep their message. The
+// fields added by the zap.Logger.With are only available with WithDedupeFields.
+func WithMessageTemplate(tmpl string) Option {
+	t := parseMessageTemplate(tmpl)
+
+	return func(o *options) {
+		o.messageTemplate = t
+	}
+}
+
+// parseMessageTemplate parses tmpl. An unterminated "{" is kept as a literal.
+func parseMessageTemplate(tmpl string) *messageTemplate {
+	t := &messageTemplate{}
+	var lit strings.Builder
+	for {
+		i := strings.IndexByte(tmpl, '{')
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(tmpl[i+1:], '}')
+		if j < 0 {
+			break
+		}
+		lit.WriteString(tmpl[:i])
+		t.literals = append(t.literals, lit.String())
+		t.names = append(t.names, tmpl[i+1:i+1+j])
+		lit.Reset()
+		tmpl = tmpl[i+1+j+1:]
+	}
+	lit.WriteString(tmpl)
+	t.literals = append(t.literals, lit.String())
+
+	return t
+}
+
+// render renders the template from the fields, and reports whether all the named fields are present.
+func (t *messageTemplate) render(fields []zapcore.Field) (string, bool) {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	var b strings.Builder
+	b.WriteString(t.literals[0])
+	for i, name := range t.names {
+		v, ok := enc.Fields[name]
+		if !ok {
+			return "", false
+		}
+		fmt.Fprint(&b, v)
+		b.WriteString(t.literals[i+1])
+	}
+
+	return b.String(), true
+}
@@ -0,0 +1,291 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"strings"
+
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	keyTrace        = "logging.googleapis.com/trace"
+	keySpanID       = "logging.googleapis.com/spanId"
+	keyTraceSampled = "logging.googleapis.com/trace_sampled"
+	keyInsertID     = "logging.googleapis.com/insertId"
+
+	// keyTraceContext is the key of the field returned by WithTraceFromTraceparent, which the
+	// Encoder expands into the trace, spanId and trace_sampled fields.
+	keyTraceContext = "logging.googleapis.com/traceContext"
+)
+
+// WithSpanID adds the Stackdriver "spanId" field, which groups the entries of a single
+// span. It can be set independently of the trace, e.g. for the jobs which have a span
+// but no distributed trace.
+//
+//  https://cloud.google.com/logging/docs/agent/configuration#special-fields
+func WithSpanID(spanID string) zapcore.Field {
+	return zap.String(keySpanID, spanID)
+}
+
+// WithInsertID adds the Stackdriver "insertId" field, and sets id to the insert ID of the entry
+// delivered to Stackdriver, which deduplicates the entries with the same timestamp and insert
+// ID, e.g. the ones written twice by a retry. It returns a no-op field if id is empty.
+func WithInsertID(id string) zapcore.Field {
+	if id == "" {
+		return zap.Skip()
+	}
+
+	return zap.String(keyInsertID, id)
+}
+
+// lastInsertID returns the value of the last "insertId" field of fields.
+func lastInsertID(fields []zapcore.Field) (string, bool) {
+	for i := len(fields) - 1; i >= 0; i-- {
+		if f := fields[i]; f.Key == keyInsertID && f.Type == zapcore.StringType {
+			return f.String, true
+		}
+	}
+
+	return "", false
+}
+
+// generateInsertID returns the insert ID derived from the timestamp, the level and the message
+// of ent. See Encoder.GenerateInsertID.
+func generateInsertID(ent zapcore.Entry) string {
+	h := fnv.New64a()
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(ent.Time.UnixNano()))
+	h.Write(b[:])
+	h.Write([]byte{byte(ent.Level)})
+	io.WriteString(h, ent.Message)
+
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// traceContext is the trace context parsed from the W3C traceparent header, or set by
+// WithCorrelation.
+type traceContext struct {
+	Trace    string // projects/[PROJECT_ID]/traces/[TRACE_ID]
+	SpanID   string
+	Sampled  bool
+	InsertID string // optional
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+//
+// The Encoder adds the fields to the entry itself rather than nesting them, see
+// addTraceContext.
+func (tc *traceContext) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString(keyTrace, tc.Trace)
+	enc.AddString(keySpanID, tc.SpanID)
+	enc.AddBool(keyTraceSampled, tc.Sampled)
+	if tc.InsertID != "" {
+		enc.AddString(keyInsertID, tc.InsertID)
+	}
+
+	return nil
+}
+
+// WithTraceFromTraceparent adds the Stackdriver "trace", "spanId" and "trace_sampled" fields
+// parsed from the W3C traceparent header, which has the "00-<trace-id>-<parent-id>-<flags>"
+// form. The trace is also set to the trace of the entry delivered to Stackdriver.
+//
+// It returns a no-op field if traceparent is malformed, or has an unsupported version.
+//
+//  https://www.w3.org/TR/trace-context/#traceparent-header
+func WithTraceFromTraceparent(traceparent, projectID string) zapcore.Field {
+	tc, ok := parseTraceparent(traceparent, projectID)
+	if !ok {
+		return zap.Skip()
+	}
+
+	return zap.Object(keyTraceContext, tc)
+}
+
+// WithTraceFromSpanContext adds the Stackdriver "trace", "spanId" and "trace_sampled" fields of
+// the OpenCensus span context, whose sampling decision is reported as "trace_sampled". The trace
+// is also set to the trace of the entry delivered to Stackdriver.
+//
+// It returns a no-op field if sc has no valid trace or span ID.
+func WithTraceFromSpanContext(sc trace.SpanContext, projectID string) zapcore.Field {
+	if sc.TraceID == (trace.TraceID{}) || sc.SpanID == (trace.SpanID{}) {
+		return zap.Skip()
+	}
+
+	return zap.Object(keyTraceContext, &traceContext{
+		Trace:   "projects/" + projectID + "/traces/" + sc.TraceID.String(),
+		SpanID:  sc.SpanID.String(),
+		Sampled: sc.IsSampled(),
+	})
+}
+
+// WithTraceContext adds the Stackdriver "trace", "spanId" and "trace_sampled" fields of the
+// active OpenCensus span of ctx, see WithTraceFromSpanContext. It returns a no-op field if ctx
+// has no active span, so the entry is left untouched.
+//
+// The sdlogging.Entry of the pinned logging client has no span ID, so the span ID is only
+// delivered in the payload.
+func WithTraceContext(ctx context.Context, projectID string) zapcore.Field {
+	span := trace.FromContext(ctx)
+	if span == nil {
+		return zap.Skip()
+	}
+
+	return WithTraceFromSpanContext(span.SpanContext(), projectID)
+}
+
+// Correlation holds the fields which correlate an entry with the other entries and the traces.
+type Correlation struct {
+	TraceID  string // trace resource name, projects/[PROJECT_ID]/traces/[TRACE_ID]
+	SpanID   string
+	Sampled  bool
+	InsertID string // unique ID of the entry, see sdlogging.Entry.InsertID; optional
+}
+
+// WithCorrelation adds the Stackdriver "trace", "spanId", "trace_sampled" and "insertId" fields
+// of c at once, so none of them is forgotten. The trace and the insert ID are also set to the
+// entry delivered to Stackdriver. The "insertId" field is omitted if c.InsertID is empty.
+//
+// Stackdriver deduplicates the entries with the same timestamp and insert ID, so a Correlation
+// with InsertID should be used for a single entry rather than added by the zap.Logger.With.
+func WithCorrelation(c Correlation) zapcore.Field {
+	return zap.Object(keyTraceContext, &traceContext{
+		Trace:    c.TraceID,
+		SpanID:   c.SpanID,
+		Sampled:  c.Sampled,
+		InsertID: c.InsertID,
+	})
+}
+
+func parseTraceparent(traceparent, projectID string) (*traceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) != 4 {
+		return nil, false
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if version != "00" {
+		return nil, false
+	}
+	if !isHex(traceID, 32) || !isHex(spanID, 16) || !isHex(flags, 2) {
+		return nil, false
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(spanID, "0") == "" {
+		return nil, false
+	}
+
+	return &traceContext{
+		Trace:   "projects/" + projectID + "/traces/" + traceID,
+		SpanID:  spanID,
+		Sampled: fromHex(flags[1])&0x1 == 1,
+	}, true
+}
+
+// parseCloudTraceContext parses the X-Cloud-Trace-Context header, which has the
+// "TRACE_ID/SPAN_ID;o=OPTIONS" form with the decimal SPAN_ID. The span ID and the options are
+// optional.
+//
+//  https://cloud.google.com/trace/docs/setup#force-trace
+func parseCloudTraceContext(header, projectID string) (*traceContext, bool) {
+	header = strings.TrimSpace(header)
+	traceID, rest := header, ""
+	if i := strings.IndexByte(header, '/'); i >= 0 {
+		traceID, rest = header[:i], header[i+1:]
+	}
+	traceID = strings.ToLower(traceID)
+	if !isHex(traceID, 32) || strings.Trim(traceID, "0") == "" {
+		return nil, false
+	}
+
+	tc := &traceContext{
+		Trace: "projects/" + projectID + "/traces/" + traceID,
+	}
+	spanID, opts := rest, ""
+	if i := strings.IndexByte(rest, ';'); i >= 0 {
+		spanID, opts = rest[:i], rest[i+1:]
+	}
+	if id, err := strconv.ParseUint(spanID, 10, 64); err == nil && id != 0 {
+		tc.SpanID = fmt.Sprintf("%016x", id)
+	}
+	tc.Sampled = opts == "o=1"
+
+	return tc, true
+}
+
+// isHex reports whether s consists of n lowercase hex digits.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func fromHex(c byte) byte {
+	if c <= '9' {
+		return c - '0'
+	}
+
+	return c - 'a' + 10
+}
+
+// expandTraceContext replaces the fields returned by WithTraceFromTraceparent with the
+// top-level trace fields, and returns the last trace context found.
+func expandTraceContext(fields []zapcore.Field) ([]zapcore.Field, *traceContext) {
+	var (
+		output []zapcore.Field
+		last   *traceContext
+	)
+	for i, f := range fields {
+		tc, ok := asTraceContext(f)
+		if !ok {
+			if output != nil {
+				output = append(output, f)
+			}
+			continue
+		}
+		if output == nil {
+			output = make([]zapcore.Field, i, len(fields)+3)
+			copy(output, fields[:i])
+		}
+		output = append(output,
+			zap.String(keyTrace, tc.Trace),
+			zap.String(keySpanID, tc.SpanID),
+			zap.Bool(keyTraceSampled, tc.Sampled),
+		)
+		if tc.InsertID != "" {
+			output = append(output, zap.String(keyInsertID, tc.InsertID))
+		}
+		last = tc
+	}
+	if output == nil {
+		return fields, nil
+	}
+
+	return output, last
+}
+
+func asTraceContext(f zapcore.Field) (*traceContext, bool) {
+	if f.Key != keyTraceContext || f.Type != zapcore.ObjectMarshalerType {
+		return nil, false
+	}
+	tc, ok := f.Interface.(*traceContext)
+
+	return tc, ok && tc != nil
+}
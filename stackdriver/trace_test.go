@@ -0,0 +1,279 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opencensus.io/trace"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/zchee/zap-encoder/internal/testutil"
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+func TestWithSpanID(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel)
+
+	logger.Info("msg", stackdriver.WithSpanID("000000000000004a"))
+
+	entries := lg.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	payload := decodePayload(t, entries[0])
+	if got, want := payload["logging.googleapis.com/spanId"], "000000000000004a"; got != want {
+		t.Errorf("got spanId %v, want %s", got, want)
+	}
+	if got, ok := payload["logging.googleapis.com/trace"]; ok {
+		t.Errorf("got trace %v, want none", got)
+	}
+	if entries[0].Trace != "" {
+		t.Errorf("got entry trace %q, want none", entries[0].Trace)
+	}
+}
+
+func TestWithTraceFromTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+
+	tests := []struct {
+		name        string
+		traceparent string
+		with        bool
+		wantSampled bool
+	}{
+		{name: "sampled", traceparent: "00-" + traceID + "-" + spanID + "-01", wantSampled: true},
+		{name: "not sampled", traceparent: "00-" + traceID + "-" + spanID + "-00", wantSampled: false},
+		{name: "with", traceparent: "00-" + traceID + "-" + spanID + "-01", with: true, wantSampled: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			lg := testutil.NewFakeLogger()
+			logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel)
+
+			field := stackdriver.WithTraceFromTraceparent(tt.traceparent, "my-project")
+			if tt.with {
+				logger.With(field).Info("msg")
+			} else {
+				logger.Info("msg", field)
+			}
+
+			entries := lg.Entries()
+			if len(entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(entries))
+			}
+			wantTrace := "projects/my-project/traces/" + traceID
+			payload := decodePayload(t, entries[0])
+			if got := payload["logging.googleapis.com/trace"]; got != wantTrace {
+				t.Errorf("got trace %v, want %s", got, wantTrace)
+			}
+			if got := payload["logging.googleapis.com/spanId"]; got != spanID {
+				t.Errorf("got spanId %v, want %s", got, spanID)
+			}
+			if got := payload["logging.googleapis.com/trace_sampled"]; got != tt.wantSampled {
+				t.Errorf("got trace_sampled %v, want %t", got, tt.wantSampled)
+			}
+			if _, ok := payload["logging.googleapis.com/traceContext"]; ok {
+				t.Error("the trace context is nested in the payload")
+			}
+			if entries[0].Trace != wantTrace {
+				t.Errorf("got entry trace %q, want %q", entries[0].Trace, wantTrace)
+			}
+		})
+	}
+}
+
+func TestWithTraceFromTraceparentMalformed(t *testing.T) {
+	for _, traceparent := range []string{
+		"",
+		"garbage",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",    // unsupported version
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",     // short trace id
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b-01",     // short span id
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",    // uppercase
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",    // zero trace id
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",    // zero span id
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz",    // invalid flags
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-ff", // extra part
+	} {
+		lg := testutil.NewFakeLogger()
+		logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel)
+		logger.Info("msg", stackdriver.WithTraceFromTraceparent(traceparent, "my-project"))
+
+		entries := lg.Entries()
+		if len(entries) != 1 {
+			t.Fatalf("%q: got %d entries, want 1", traceparent, len(entries))
+		}
+		payload := decodePayload(t, entries[0])
+		for _, key := range []string{"logging.googleapis.com/trace", "logging.googleapis.com/spanId", "logging.googleapis.com/trace_sampled"} {
+			if got, ok := payload[key]; ok {
+				t.Errorf("%q: got %s %v, want none", traceparent, key, got)
+			}
+		}
+		if entries[0].Trace != "" {
+			t.Errorf("%q: got entry trace %q, want none", traceparent, entries[0].Trace)
+		}
+	}
+}
+
+func TestWithTraceFromSpanContext(t *testing.T) {
+	sc := trace.SpanContext{
+		TraceID: trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	}
+
+	for _, sampled := range []bool{true, false} {
+		sc := sc
+		if sampled {
+			sc.TraceOptions = 1
+		}
+
+		lg := testutil.NewFakeLogger()
+		logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel)
+		logger.Info("msg", stackdriver.WithTraceFromSpanContext(sc, "my-project"))
+
+		entry := lg.Entries()[0]
+		payload := decodePayload(t, entry)
+		if got := payload["logging.googleapis.com/trace_sampled"]; got != sampled {
+			t.Errorf("sampled %t: got trace_sampled %v", sampled, got)
+		}
+		if got, want := payload["logging.googleapis.com/spanId"], "00f067aa0ba902b7"; got != want {
+			t.Errorf("sampled %t: got spanId %v, want %s", sampled, got, want)
+		}
+		if want := "projects/my-project/traces/4bf92f3577b34da6a3ce929d0e0e4736"; entry.Trace != want {
+			t.Errorf("sampled %t: got entry trace %q, want %q", sampled, entry.Trace, want)
+		}
+	}
+
+	lg := testutil.NewFakeLogger()
+	logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel)
+	logger.Info("msg", stackdriver.WithTraceFromSpanContext(trace.SpanContext{}, "my-project"))
+	if _, ok := decodePayload(t, lg.Entries()[0])["logging.googleapis.com/trace_sampled"]; ok {
+		t.Error("got trace_sampled for the invalid span context")
+	}
+}
+
+func TestWithTraceContext(t *testing.T) {
+	ctx, span := trace.StartSpan(context.Background(), "request", trace.WithSampler(trace.AlwaysSample()))
+	defer span.End()
+	sc := span.SpanContext()
+
+	lg := testutil.NewFakeLogger()
+	logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel)
+	logger.Info("in span", stackdriver.WithTraceContext(ctx, "my-project"))
+	logger.Info("no span", stackdriver.WithTraceContext(context.Background(), "my-project"))
+
+	entries := lg.Entries()
+	if want := "projects/my-project/traces/" + sc.TraceID.String(); entries[0].Trace != want {
+		t.Errorf("got entry trace %q, want %q", entries[0].Trace, want)
+	}
+	if got, want := decodePayload(t, entries[0])["logging.googleapis.com/spanId"], sc.SpanID.String(); got != want {
+		t.Errorf("got spanId %v, want %s", got, want)
+	}
+
+	if entries[1].Trace != "" {
+		t.Errorf("got entry trace %q without a span, want none", entries[1].Trace)
+	}
+	if _, ok := decodePayload(t, entries[1])["logging.googleapis.com/spanId"]; ok {
+		t.Error("got spanId without a span")
+	}
+}
+
+func TestWithCorrelation(t *testing.T) {
+	const trace = "projects/my-project/traces/4bf92f3577b34da6a3ce929d0e0e4736"
+
+	for _, with := range []bool{false, true} {
+		lg := testutil.NewFakeLogger()
+		logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel)
+
+		field := stackdriver.WithCorrelation(stackdriver.Correlation{
+			TraceID:  trace,
+			SpanID:   "00f067aa0ba902b7",
+			Sampled:  true,
+			InsertID: "insert-1",
+		})
+		if with {
+			logger.With(field).Info("msg")
+		} else {
+			logger.Info("msg", field)
+		}
+
+		entry := lg.Entries()[0]
+		payload := decodePayload(t, entry)
+		for key, want := range map[string]interface{}{
+			"logging.googleapis.com/trace":         trace,
+			"logging.googleapis.com/spanId":        "00f067aa0ba902b7",
+			"logging.googleapis.com/trace_sampled": true,
+			"logging.googleapis.com/insertId":      "insert-1",
+		} {
+			if got := payload[key]; got != want {
+				t.Errorf("with=%t: got %s %v, want %v", with, key, got, want)
+			}
+		}
+		if entry.Trace != trace {
+			t.Errorf("with=%t: got entry trace %q, want %q", with, entry.Trace, trace)
+		}
+		if entry.InsertID != "insert-1" {
+			t.Errorf("with=%t: got entry insert ID %q, want %q", with, entry.InsertID, "insert-1")
+		}
+	}
+}
+
+func TestWithInsertID(t *testing.T) {
+	lg := testutil.NewFakeLogger()
+	logger := stackdriver.NewLogger(context.Background(), lg, zapcore.InfoLevel)
+	logger.Info("call", stackdriver.WithInsertID("id-1"))
+	logger.With(stackdriver.WithInsertID("id-2")).Info("with")
+	logger.Info("none")
+
+	for i, want := range []string{"id-1", "id-2", ""} {
+		if got := lg.Entries()[i].InsertID; got != want {
+			t.Errorf("#%d: got insert ID %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestEncoderGenerateInsertID(t *testing.T) {
+	now := time.Date(2018, 6, 19, 16, 33, 42, 0, time.UTC)
+
+	lg := testutil.NewFakeLogger()
+	enc := stackdriver.NewStackdriverEncoder(context.Background(), lg, stackdriver.NewStackdriverEncoderConfig()).(*stackdriver.Encoder)
+	enc.GenerateInsertID = true
+
+	entries := []struct {
+		ent    zapcore.Entry
+		fields []zapcore.Field
+	}{
+		{ent: zapcore.Entry{Time: now, Message: "retried"}},
+		{ent: zapcore.Entry{Time: now, Message: "retried"}},
+		{ent: zapcore.Entry{Time: now, Message: "other"}},
+		{ent: zapcore.Entry{Time: now, Message: "retried"}, fields: []zapcore.Field{stackdriver.WithInsertID("explicit")}},
+	}
+	for _, e := range entries {
+		buf, err := enc.EncodeEntry(e.ent, e.fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Free()
+	}
+
+	got := lg.Entries()
+	if got[0].InsertID == "" || got[0].InsertID != got[1].InsertID {
+		t.Errorf("got insert IDs %q and %q for the retried entry, want the same one", got[0].InsertID, got[1].InsertID)
+	}
+	if got[2].InsertID == got[0].InsertID {
+		t.Errorf("got insert ID %q for the different message, want another one", got[2].InsertID)
+	}
+	if got[3].InsertID != "explicit" {
+		t.Errorf("got insert ID %q, want the explicit one", got[3].InsertID)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver
+
+import (
+	"io"
+	"sync"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// writerSyncer is a zapcore.WriteSyncer which writes each entry as a JSON line to w.
+type writerSyncer struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+}
+
+//pragma: compiler time checks whether the writerSyncer implemented zapcore.WriteSyncer interface.
+var _ zapcore.WriteSyncer = (*writerSyncer)(nil)
+
+// NewWriterSyncer returns a zapcore.WriteSyncer which writes each encoded entry followed by a
+// newline to w, e.g. a file or a socket, so the stackdriver encoder can be used for its fields
+// shape without the logging client. Sync flushes w if it implements Sync() error or Flush() error.
+func NewWriterSyncer(w io.Writer) zapcore.WriteSyncer {
+	return &writerSyncer{
+		w: w,
+	}
+}
+
+// Write implements zapcore.WriteSyncer.
+func (ws *writerSyncer) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	p := b
+	if b[len(b)-1] != '\n' {
+		// write the entry and the newline at once to not interleave with the other writers of w.
+		ws.buf = append(append(ws.buf[:0], b...), '\n')
+		p = ws.buf
+	}
+	if _, err := ws.w.Write(p); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// Sync implements zapcore.WriteSyncer.
+func (ws *writerSyncer) Sync() error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	switch w := ws.w.(type) {
+	case interface{ Sync() error }:
+		return w.Sync()
+	case interface{ Flush() error }:
+		return w.Flush()
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 The zap-encoder Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackdriver_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/zchee/zap-encoder/stackdriver"
+)
+
+func TestNewWriterSyncer(t *testing.T) {
+	for _, lineEnding := range []string{"\n", ""} {
+		cfg := stackdriver.NewStackdriverEncoderConfig()
+		cfg.LineEnding = lineEnding
+		if lineEnding == "" {
+			// zap falls back to the default line ending for the empty one, use a
+			// custom separator to check the framing newline is added.
+			cfg.LineEnding = " "
+		}
+
+		var out bytes.Buffer
+		w := bufio.NewWriter(&out)
+		enc := stackdriver.NewStackdriverEncoder(context.Background(), nil, cfg)
+		logger := zap.New(zapcore.NewCore(enc, stackdriver.NewWriterSyncer(w), zapcore.InfoLevel))
+
+		for _, msg := range []string{"first", "second", "third"} {
+			logger.Info(msg, zap.String("key", "multi\nline"))
+		}
+		if out.Len() != 0 {
+			t.Fatalf("%q: written before Sync: %q", cfg.LineEnding, out.String())
+		}
+		if err := logger.Sync(); err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.SplitAfter(out.String(), "\n")
+		if last := lines[len(lines)-1]; last != "" {
+			t.Fatalf("%q: got unterminated line %q", cfg.LineEnding, last)
+		}
+		lines = lines[:len(lines)-1]
+		if len(lines) != 3 {
+			t.Fatalf("%q: got %d lines, want 3: %q", cfg.LineEnding, len(lines), out.String())
+		}
+		for i, want := range []string{"first", "second", "third"} {
+			var payload map[string]interface{}
+			if err := json.Unmarshal([]byte(lines[i]), &payload); err != nil {
+				t.Fatalf("%q: line %d: %v", cfg.LineEnding, i, err)
+			}
+			if payload["message"] != want {
+				t.Errorf("%q: line %d: got message %v, want %s", cfg.LineEnding, i, payload["message"], want)
+			}
+		}
+	}
+}